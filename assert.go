@@ -0,0 +1,220 @@
+package pqt
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+// Text of NULL values in formatted tables.
+const nullText = "(null)"
+
+// Converts a value to text the same way database/sql converts
+// values returned by the driver to strings. Returns nil for nil.
+func formatCell(value interface{}) *string {
+	if value == nil {
+		return nil
+	}
+	text := formatValue(value)
+	return &text
+}
+
+// Converts a non-nil value to text.
+func formatValue(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case bool:
+		return strconv.FormatBool(v)
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case float32:
+		return strconv.FormatFloat(float64(v), 'g', -1, 32)
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// Formats rows as psql aligned table, NULLs are shown as (null).
+// Cells marked in diff are wrapped in asterisks.
+func formatTable(columns []string, rows [][]*string, diff [][]bool) string {
+	var b strings.Builder
+
+	cell := func(row, col int) string {
+		if col >= len(rows[row]) {
+			return ""
+		}
+		text := nullText
+		if rows[row][col] != nil {
+			text = *rows[row][col]
+		}
+		if diff != nil && diff[row][col] {
+			return "*" + text + "*"
+		}
+		return text
+	}
+
+	ncolumns := len(columns)
+	for _, row := range rows {
+		if len(row) > ncolumns {
+			ncolumns = len(row)
+		}
+	}
+
+	widths := make([]int, ncolumns)
+	for i, column := range columns {
+		widths[i] = utf8.RuneCountInString(column)
+	}
+	for i := range rows {
+		for j := range rows[i] {
+			if w := utf8.RuneCountInString(cell(i, j)); w > widths[j] {
+				widths[j] = w
+			}
+		}
+	}
+
+	line := func(values []string) {
+		for i := 0; i < ncolumns; i++ {
+			var value string
+			if i < len(values) {
+				value = values[i]
+			}
+			if i > 0 {
+				b.WriteString("|")
+			}
+			b.WriteString(" " + value +
+				strings.Repeat(" ", widths[i]-utf8.RuneCountInString(value)) + " ")
+		}
+		b.WriteString("\n")
+	}
+
+	line(columns)
+	for i := 0; i < ncolumns; i++ {
+		if i > 0 {
+			b.WriteString("+")
+		}
+		b.WriteString(strings.Repeat("-", widths[i]+2))
+	}
+	b.WriteString("\n")
+
+	for i := range rows {
+		values := make([]string, len(rows[i]))
+		for j := range rows[i] {
+			values[j] = cell(i, j)
+		}
+		line(values)
+	}
+
+	if len(rows) == 1 {
+		b.WriteString("(1 row)\n")
+	} else {
+		fmt.Fprintf(&b, "(%d rows)\n", len(rows))
+	}
+	return b.String()
+}
+
+// Formats the table like psql does.
+func (table *Table) String() string {
+	return formatTable(table.Columns, table.cells(), nil)
+}
+
+// Checks that both values are NULLs or equal strings.
+func equalCells(a, b *string) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return *a == *b
+}
+
+// Marks cells that differ between two tables. Returns nil if tables
+// are equal.
+func diffTables(a, b [][]*string) (diffA, diffB [][]bool) {
+	equal := len(a) == len(b)
+
+	mark := func(rows, other [][]*string) [][]bool {
+		diff := make([][]bool, len(rows))
+		for i := range rows {
+			diff[i] = make([]bool, len(rows[i]))
+			for j := range rows[i] {
+				if i >= len(other) || j >= len(other[i]) ||
+					!equalCells(rows[i][j], other[i][j]) {
+
+					diff[i][j] = true
+					equal = false
+				}
+			}
+			if i < len(other) && len(rows[i]) != len(other[i]) {
+				equal = false
+			}
+		}
+		return diff
+	}
+
+	diffA = mark(a, b)
+	diffB = mark(b, a)
+	if equal {
+		return nil, nil
+	}
+	return diffA, diffB
+}
+
+// Asserts that the query returns expected rows. Values are compared
+// as text, NULLs are only equal to nil. On mismatch both tables are
+// reported with differing cells marked.
+func AssertQueryResult(t testing.TB, node *PostgresNode, dbname string,
+	query string, expected [][]interface{}) bool {
+
+	t.Helper()
+
+	actual, err := node.QueryTable(dbname, query)
+	if err != nil {
+		t.Errorf("query failed: %s\n%s", err, query)
+		return false
+	}
+
+	expectedRows := make([][]*string, len(expected))
+	for i, row := range expected {
+		expectedRows[i] = make([]*string, len(row))
+		for j, value := range row {
+			expectedRows[i][j] = formatCell(value)
+		}
+	}
+
+	actualRows := actual.cells()
+	diffExpected, diffActual := diffTables(expectedRows, actualRows)
+	if diffExpected == nil {
+		return true
+	}
+
+	t.Errorf("query result doesn't match:\n%s\n\nexpected:\n%s\nactual:\n%s",
+		query,
+		formatTable(actual.Columns, expectedRows, diffExpected),
+		formatTable(actual.Columns, actualRows, diffActual))
+	return false
+}
+
+// Asserts that the query returns no rows.
+func AssertQueryEmpty(t testing.TB, node *PostgresNode, dbname string,
+	query string) bool {
+
+	t.Helper()
+
+	actual, err := node.QueryTable(dbname, query)
+	if err != nil {
+		t.Errorf("query failed: %s\n%s", err, query)
+		return false
+	}
+
+	if len(actual.Rows) != 0 {
+		t.Errorf("query result is not empty:\n%s\n\n%s", query, actual)
+		return false
+	}
+	return true
+}
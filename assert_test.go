@@ -0,0 +1,110 @@
+package pqt
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+type recordingTB struct {
+	testing.TB
+	errors []string
+}
+
+func (tb *recordingTB) Helper() {}
+
+func (tb *recordingTB) Errorf(format string, args ...interface{}) {
+	tb.errors = append(tb.errors, fmt.Sprintf(format, args...))
+}
+
+// Makes table cells from strings, "(null)" is NULL.
+func makeCells(rows [][]string) [][]*string {
+	cells := make([][]*string, len(rows))
+	for i := range rows {
+		cells[i] = make([]*string, len(rows[i]))
+		for j := range rows[i] {
+			if rows[i][j] != nullText {
+				cells[i][j] = &rows[i][j]
+			}
+		}
+	}
+	return cells
+}
+
+func TestFormatTable(t *testing.T) {
+	expected := ` id | name  
+----+-------
+ 1  | one   
+ 2  | *two* 
+(2 rows)
+`
+	rows := makeCells([][]string{{"1", "one"}, {"2", "two"}})
+	diff, _ := diffTables(rows, makeCells([][]string{{"1", "one"}, {"2", "three"}}))
+	assert.Equal(t, expected, formatTable([]string{"id", "name"}, rows, diff))
+
+	diff, _ = diffTables(rows, makeCells([][]string{{"1", "one"}, {"2", "two"}}))
+	assert.Nil(t, diff)
+
+	diff, _ = diffTables(rows, makeCells([][]string{{"1", "one"}}))
+	assert.Equal(t, diff, [][]bool{{false, false}, {true, true}})
+
+	// NULLs are not equal to empty strings
+	rows = makeCells([][]string{{"1", ""}})
+	diff, _ = diffTables(rows, makeCells([][]string{{"1", nullText}}))
+	assert.Equal(t, diff, [][]bool{{false, true}})
+
+	diff, _ = diffTables(makeCells([][]string{{nullText}}),
+		makeCells([][]string{{nullText}}))
+	assert.Nil(t, diff)
+
+	expected = ` id | name   
+----+--------
+ 1  | (null) 
+(1 row)
+`
+	assert.Equal(t, expected, formatTable([]string{"id", "name"},
+		makeCells([][]string{{"1", nullText}}), nil))
+}
+
+func TestFormatValue(t *testing.T) {
+	assert.Nil(t, formatCell(nil))
+	assert.Equal(t, *formatCell(""), "")
+	assert.Equal(t, formatValue(1), "1")
+	assert.Equal(t, formatValue(1.5), "1.5")
+	assert.Equal(t, formatValue(true), "true")
+	assert.Equal(t, formatValue([]byte("x")), "x")
+	assert.Equal(t, formatValue(time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)),
+		"2020-01-02T03:04:05Z")
+}
+
+func TestAssertQueryResult(t *testing.T) {
+	node := MakePostgresNode("master")
+	node.Init()
+	node.Start()
+	node.Execute("postgres", "create table items(id int, name text)")
+	node.Execute("postgres", "insert into items values (1, 'one'), (2, null)")
+
+	query := "select * from items order by id"
+	assert.True(t, AssertQueryResult(t, node, "postgres", query,
+		[][]interface{}{{1, "one"}, {2, nil}}))
+	assert.True(t, AssertQueryEmpty(t, node, "postgres",
+		"select * from items where id > 2"))
+
+	tb := &recordingTB{TB: t}
+	assert.False(t, AssertQueryResult(tb, node, "postgres", query,
+		[][]interface{}{{1, "one"}, {2, "two"}}))
+	assert.Equal(t, len(tb.errors), 1)
+	assert.Contains(t, tb.errors[0], "*two*")
+
+	// NULL doesn't match an empty string
+	assert.False(t, AssertQueryResult(tb, node, "postgres", query,
+		[][]interface{}{{1, "one"}, {2, ""}}))
+	assert.Equal(t, len(tb.errors), 2)
+	assert.Contains(t, tb.errors[1], "*(null)*")
+
+	assert.False(t, AssertQueryEmpty(tb, node, "postgres", query))
+	assert.Equal(t, len(tb.errors), 3)
+
+	node.Stop()
+}
@@ -0,0 +1,106 @@
+package pqt
+
+import (
+	"context"
+	"fmt"
+)
+
+// Result of a query executed asynchronously.
+type AsyncResult struct {
+	conn *PostgresConn
+	done chan struct{}
+	err  error
+}
+
+// Executes query on the connection without waiting for its completion.
+// The connection shouldn't be used until the query is completed.
+// When the context is done, the query is canceled with pg_cancel_backend
+// and the connection can be used after that.
+func (conn *PostgresConn) ExecuteAsync(ctx context.Context, sql string,
+	params ...interface{}) *AsyncResult {
+
+	// pid can't be queried while the query is running
+	conn.Pid()
+
+	res := &AsyncResult{
+		conn: conn,
+		done: make(chan struct{}),
+	}
+
+	go func() {
+		res.err = conn.ExecContext(ctx, sql, params...)
+		close(res.done)
+	}()
+
+	return res
+}
+
+// Returns a channel that is closed when the query completes.
+func (res *AsyncResult) Done() <-chan struct{} {
+	return res.done
+}
+
+// Waits for the query completion and returns its error.
+func (res *AsyncResult) Wait() error {
+	<-res.done
+	return res.err
+}
+
+// Signals the backend with pg_cancel_backend or pg_terminate_backend.
+func (node *PostgresNode) signalBackend(dbname string, function string,
+	pid int) error {
+
+	var signaled bool
+
+	conn := MakePostgresConn(node, dbname)
+	defer conn.Close()
+
+	err := conn.QueryRow("select "+function+"($1)", pid).Scan(&signaled)
+	if err != nil {
+		return err
+	}
+	if !signaled {
+		return fmt.Errorf("%s(%d) has failed", function, pid)
+	}
+	return nil
+}
+
+// Cancels the query with pg_cancel_backend. The query fails with
+// "canceling statement due to user request" error, the connection
+// can be used after that.
+func (res *AsyncResult) Cancel() error {
+	return res.conn.node.signalBackend(res.conn.dbname, "pg_cancel_backend",
+		res.conn.Pid())
+}
+
+// Terminates the backend executing the query with pg_terminate_backend.
+// The connection can't be used after that.
+func (res *AsyncResult) Terminate() error {
+	return res.conn.node.signalBackend(res.conn.dbname, "pg_terminate_backend",
+		res.conn.Pid())
+}
+
+// Waits until the query gets blocked by a lock or completes.
+// Returns true if the query is blocked.
+func (res *AsyncResult) WaitUntilBlocked(ctx context.Context) (bool, error) {
+	var blocked bool
+
+	pid := res.conn.Pid()
+
+	monitor := MakePostgresConn(res.conn.node, res.conn.dbname)
+	defer monitor.Close()
+
+	query := blockedQuery(res.conn.node.Version())
+	err := poll(ctx, func() (bool, error) {
+		select {
+		case <-res.done:
+			return true, nil
+		default:
+		}
+
+		var err error
+		blocked, err = backendBlocked(monitor, pid, query)
+		return blocked, err
+	})
+	return blocked, err
+}
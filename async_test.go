@@ -0,0 +1,97 @@
+package pqt
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestAsyncCancel(t *testing.T) {
+	node := MakePostgresNode("master")
+	node.Init()
+	node.Start()
+
+	conn := MakePostgresConn(node, "postgres")
+
+	t.Run("cancel", func(t *testing.T) {
+		res := conn.ExecuteAsync(context.Background(), "select pg_sleep(60)")
+		time.Sleep(100 * time.Millisecond)
+		assert.Nil(t, res.Cancel())
+
+		err := res.Wait()
+		assert.NotNil(t, err)
+		assert.Contains(t, err.Error(), "canceling statement due to user request")
+
+		// connection is still usable
+		conn.Execute("select 1")
+	})
+
+	t.Run("statement timeout", func(t *testing.T) {
+		conn.Execute("set statement_timeout = '100ms'")
+		err := conn.ExecContext(context.Background(), "select pg_sleep(60)")
+		assert.NotNil(t, err)
+		assert.Contains(t, err.Error(), "statement timeout")
+		conn.Execute("reset statement_timeout")
+	})
+
+	t.Run("context", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+
+		started := time.Now()
+		err := conn.ExecContext(ctx, "select pg_sleep(60)")
+		assert.True(t, errors.Is(err, context.DeadlineExceeded))
+		assert.Contains(t, err.Error(), "canceling statement due to user request")
+		assert.True(t, time.Since(started) < 30*time.Second)
+
+		// connection is still usable
+		conn.Execute("select 1")
+	})
+
+	t.Run("fetch context", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+
+		// the error is reported by the query or by reading the rows
+		rows, err := conn.QueryContext(ctx, "select pg_sleep(60)")
+		if err == nil {
+			assert.False(t, rows.Next())
+			err = rows.Err()
+			rows.Close()
+		}
+		assert.NotNil(t, err)
+		assert.Contains(t, err.Error(), "canceling statement due to user request")
+
+		// connection is still usable
+		rows = conn.Fetch("select 1")
+		assert.True(t, rows.Next())
+		rows.Close()
+
+		_, err = conn.QueryContext(ctx, "select 1")
+		assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	})
+
+	t.Run("async context", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+
+		res := conn.ExecuteAsync(ctx, "select pg_sleep(60)")
+		time.Sleep(100 * time.Millisecond)
+		cancel()
+		assert.True(t, errors.Is(res.Wait(), context.Canceled))
+
+		conn.Execute("select 1")
+		assert.Nil(t, conn.ExecContext(context.Background(), "select 1"))
+	})
+
+	t.Run("terminate", func(t *testing.T) {
+		res := conn.ExecuteAsync(context.Background(), "select pg_sleep(60)")
+		time.Sleep(100 * time.Millisecond)
+		assert.Nil(t, res.Terminate())
+		assert.NotNil(t, res.Wait())
+	})
+
+	conn.Close()
+	node.Stop()
+}
@@ -0,0 +1,85 @@
+package pqt
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// Row of pg_stat_activity.
+type BackendActivity struct {
+	Pid             int          `db:"pid"`
+	BackendType     string       `db:"backend_type"`
+	Database        string       `db:"datname"`
+	User            string       `db:"usename"`
+	ApplicationName string       `db:"application_name"`
+	State           string       `db:"state"`
+	WaitEventType   string       `db:"wait_event_type"`
+	WaitEvent       string       `db:"wait_event"`
+	Query           string       `db:"query"`
+	XactStart       sql.NullTime `db:"xact_start"`
+}
+
+// Child process of postmaster with its pg_stat_activity row.
+// Activity is nil for processes not shown in pg_stat_activity.
+type Backend struct {
+	Process  *Process
+	Activity *BackendActivity
+}
+
+// Returns the query for pg_stat_activity of the postgres version.
+func activityQuery(version int) string {
+	backendType := "backend_type"
+	if version < 100000 {
+		backendType = "'client backend'"
+	}
+
+	waitEvent := `coalesce(wait_event_type, '') as wait_event_type,
+		coalesce(wait_event, '') as wait_event`
+	if version < 90600 {
+		waitEvent = `case when waiting then 'Lock' else '' end as wait_event_type,
+		'' as wait_event`
+	}
+
+	return fmt.Sprintf(`select pid, coalesce(%s, '') as backend_type,
+		coalesce(datname, '') as datname, coalesce(usename, '') as usename,
+		coalesce(application_name, '') as application_name,
+		coalesce(state, '') as state, %s,
+		coalesce(query, '') as query, xact_start
+		from pg_stat_activity where pid <> pg_backend_pid()`,
+		backendType, waitEvent)
+}
+
+// Returns child processes of postmaster joined with pg_stat_activity.
+// The query is made by a separate connection, which is not included.
+func (node *PostgresNode) Backends() ([]*Backend, error) {
+	conn := MakePostgresConn(node, "postgres")
+	defer conn.Close()
+
+	activities, err := QueryStructs[BackendActivity](conn,
+		activityQuery(node.Version()))
+	if err != nil {
+		return nil, err
+	}
+
+	byPid := make(map[int]*BackendActivity)
+	for i := range activities {
+		byPid[activities[i].Pid] = &activities[i]
+	}
+
+	postmaster := node.GetProcess()
+	if postmaster == nil {
+		return nil, fmt.Errorf("postmaster of %s is not running", node.name)
+	}
+
+	var backends []*Backend
+	for _, process := range postmaster.Children() {
+		if process.Pid == conn.Pid() {
+			continue
+		}
+		backends = append(backends, &Backend{
+			Process:  process,
+			Activity: byPid[process.Pid],
+		})
+	}
+	return backends, nil
+}
@@ -0,0 +1,47 @@
+package pqt
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestActivityQuery(t *testing.T) {
+	assert.Contains(t, activityQuery(170000), "coalesce(backend_type, '')")
+	assert.Contains(t, activityQuery(90600), "'client backend'")
+	assert.Contains(t, activityQuery(90500), "when waiting")
+}
+
+func TestBackends(t *testing.T) {
+	node := MakePostgresNode("master")
+	node.Init()
+	node.Start()
+	defer node.Stop()
+
+	conn := MakePostgresConn(node, "postgres")
+	conn.Execute("set application_name = 'pqt_backends'")
+
+	// the default connection to other database is kept
+	other := node.Conn("template1")
+
+	backends, err := node.Backends()
+	assert.Nil(t, err)
+	assert.NotEqual(t, 0, len(backends))
+	assert.Equal(t, other, node.Conn("template1"))
+
+	var found *Backend
+	foundOther := false
+	for _, backend := range backends {
+		if backend.Process.Pid == conn.Pid() {
+			found = backend
+		}
+		foundOther = foundOther || backend.Process.Pid == other.Pid()
+	}
+	assert.True(t, foundOther)
+
+	assert.NotNil(t, found)
+	assert.Equal(t, ClientBackend, found.Process.Type)
+	assert.Equal(t, "pqt_backends", found.Activity.ApplicationName)
+	assert.Equal(t, "postgres", found.Activity.Database)
+	assert.Equal(t, "idle", found.Activity.State)
+	assert.False(t, found.Activity.XactStart.Valid)
+}
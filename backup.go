@@ -17,17 +17,29 @@ type ReplicaNode struct {
 // Creates a replica for specified master node.
 func MakeReplicaNode(name string, master *PostgresNode) *ReplicaNode {
 	node := &ReplicaNode{*MakePostgresNode(name), master}
+	node.install = master.install
 	return node
 }
 
-// Write default recovery.conf.
+// Write default recovery configuration. Since postgres 12 it's a part
+// of postgresql.conf, and standby mode is set by standby.signal file.
 func (node *ReplicaNode) writeRecoveryConf() {
-	lines := `
-primary_conninfo = 'application_name=%s port=%d user=%s hostaddr=127.0.0.1'
-standby_mode = on
-`
+	conninfo := fmt.Sprintf(
+		"primary_conninfo = 'application_name=%s port=%d user=%s hostaddr=127.0.0.1'\n",
+		node.name, node.Master.Port, node.user)
 
-	lines = fmt.Sprintf(lines, node.name, node.Port, node.user)
+	if node.Version() >= 120000 {
+		node.AppendConf("postgresql.conf", conninfo)
+
+		signalFile := filepath.Join(node.dataDirectory, "standby.signal")
+		err := ioutil.WriteFile(signalFile, nil, 0600)
+		if err != nil {
+			log.Panic("can't write standby.signal: ", err)
+		}
+		return
+	}
+
+	lines := conninfo + "standby_mode = on\n"
 	confFile := filepath.Join(node.dataDirectory, "recovery.conf")
 	err := ioutil.WriteFile(confFile, []byte(lines), os.ModePerm)
 
@@ -59,11 +71,11 @@ func (node *ReplicaNode) Init(params ...string) (string, error) {
 		"-X", "fetch",
 	}
 	args = append(args, params...)
-	res := execUtility("pg_basebackup", args...)
+	res := node.execUtility("pg_basebackup", args...)
 
 	node.initDefaultConf()
-	node.writeRecoveryConf()
 	node.status = STOPPED
+	node.writeRecoveryConf()
 	return res, nil
 }
 
@@ -74,6 +86,11 @@ func (node *ReplicaNode) Catchup() {
 	poll_lsn := "select pg_current_wal_lsn()::text"
 	wait_lsn := "select pg_last_wal_replay_lsn() >= '%s'::pg_lsn"
 
+	if node.Master.Version() < 100000 {
+		poll_lsn = "select pg_current_xlog_location()::text"
+		wait_lsn = "select pg_last_xlog_replay_location() >= '%s'::pg_lsn"
+	}
+
 	rows := node.Master.Fetch("postgres", poll_lsn)
 	rows.Next()
 
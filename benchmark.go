@@ -0,0 +1,307 @@
+package pqt
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Two-sided 95% critical values of Student's t-distribution
+// for 1..30 degrees of freedom.
+var tCritical95 = []float64{
+	12.706, 4.303, 3.182, 2.776, 2.571, 2.447, 2.365, 2.306, 2.262, 2.228,
+	2.201, 2.179, 2.160, 2.145, 2.131, 2.120, 2.110, 2.101, 2.093, 2.086,
+	2.080, 2.074, 2.069, 2.064, 2.060, 2.056, 2.052, 2.048, 2.045, 2.042,
+}
+
+// Workload for benchmark comparison. Either pgbench options or a function
+// should be specified.
+type BenchmarkWorkload struct {
+	// Runs pgbench with these options.
+	Pgbench *PgbenchOptions
+
+	// Function called repeatedly by every client until the duration
+	// passes. Every call is counted as one transaction.
+	Func func(conn *PostgresConn) error
+
+	// Database, clients and duration for Func workload.
+	Database string
+	Clients  int
+	Duration time.Duration
+
+	// Called before every run, for example to make a checkpoint.
+	BeforeRun func(node *PostgresNode)
+}
+
+// Node to benchmark, for example one built with another installation
+// or started with another configuration.
+type BenchmarkTarget struct {
+	Name string
+	Node *PostgresNode
+}
+
+// Result of one benchmark run.
+type BenchmarkSample struct {
+	TPS            float64
+	LatencyAverage time.Duration
+}
+
+// Statistics over samples. Change is relative change of Mean against
+// the baseline, with 95% confidence interval (ChangeLow, ChangeHigh).
+type BenchmarkSummary struct {
+	Mean       float64
+	Stddev     float64
+	CI         float64
+	Change     float64
+	ChangeLow  float64
+	ChangeHigh float64
+}
+
+// Results of all runs for a target.
+type BenchmarkStats struct {
+	Target  *BenchmarkTarget
+	Samples []BenchmarkSample
+	TPS     BenchmarkSummary
+	Latency BenchmarkSummary // in milliseconds
+}
+
+// Comparison report. The first target is the baseline.
+type BenchmarkReport struct {
+	Stats []*BenchmarkStats
+}
+
+func tValue95(df int) float64 {
+	if df < 1 {
+		return math.NaN()
+	}
+	if df > len(tCritical95) {
+		return 1.96
+	}
+	return tCritical95[df-1]
+}
+
+func meanStddev(values []float64) (mean, stddev float64) {
+	if len(values) == 0 {
+		return 0, 0
+	}
+
+	for _, v := range values {
+		mean += v
+	}
+	mean /= float64(len(values))
+
+	if len(values) > 1 {
+		for _, v := range values {
+			stddev += (v - mean) * (v - mean)
+		}
+		stddev = math.Sqrt(stddev / float64(len(values)-1))
+	}
+	return mean, stddev
+}
+
+// Summarizes values and compares them with the baseline values.
+func summarize(values, baseline []float64) BenchmarkSummary {
+	var s BenchmarkSummary
+
+	n := len(values)
+	s.Mean, s.Stddev = meanStddev(values)
+	s.CI = tValue95(n-1) * s.Stddev / math.Sqrt(float64(n))
+
+	baseMean, baseStddev := meanStddev(baseline)
+	if baseMean == 0 {
+		return s
+	}
+
+	// confidence interval of means difference, with conservative
+	// degrees of freedom instead of Welch-Satterthwaite approximation
+	df := n - 1
+	if len(baseline)-1 < df {
+		df = len(baseline) - 1
+	}
+	se := math.Sqrt(s.Stddev*s.Stddev/float64(n) +
+		baseStddev*baseStddev/float64(len(baseline)))
+	diff := s.Mean - baseMean
+	margin := tValue95(df) * se
+
+	s.Change = diff / baseMean
+	s.ChangeLow = (diff - margin) / baseMean
+	s.ChangeHigh = (diff + margin) / baseMean
+	return s
+}
+
+// Runs Func workload with concurrent clients.
+func (workload *BenchmarkWorkload) runFunc(node *PostgresNode) (BenchmarkSample, error) {
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	var count int64
+	var latency time.Duration
+	var firstErr error
+
+	dbname := workload.Database
+	if dbname == "" {
+		dbname = "postgres"
+	}
+
+	clients := workload.Clients
+	if clients < 1 {
+		clients = 1
+	}
+
+	start := time.Now()
+	deadline := start.Add(workload.Duration)
+
+	for i := 0; i < clients; i += 1 {
+		wg.Add(1)
+		go func() {
+			var clientCount int64
+			var clientLatency time.Duration
+			var err error
+
+			defer wg.Done()
+
+			conn := MakePostgresConn(node, dbname)
+			defer conn.Close()
+
+			for time.Now().Before(deadline) {
+				started := time.Now()
+				if err = workload.Func(conn); err != nil {
+					break
+				}
+				clientLatency += time.Since(started)
+				clientCount += 1
+			}
+
+			mutex.Lock()
+			count += clientCount
+			latency += clientLatency
+			if err != nil && firstErr == nil {
+				firstErr = err
+			}
+			mutex.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return BenchmarkSample{}, firstErr
+	}
+	if count == 0 {
+		return BenchmarkSample{}, errors.New("no transactions were made")
+	}
+
+	return BenchmarkSample{
+		TPS:            float64(count) / time.Since(start).Seconds(),
+		LatencyAverage: latency / time.Duration(count),
+	}, nil
+}
+
+func (workload *BenchmarkWorkload) run(node *PostgresNode) (BenchmarkSample, error) {
+	if workload.BeforeRun != nil {
+		workload.BeforeRun(node)
+	}
+
+	if workload.Pgbench != nil {
+		res, err := node.Pgbench(workload.Pgbench)
+		if err != nil {
+			return BenchmarkSample{}, err
+		}
+		return BenchmarkSample{
+			TPS:            res.TPS,
+			LatencyAverage: res.LatencyAverage,
+		}, nil
+	}
+
+	return workload.runFunc(node)
+}
+
+// Runs the workload against every target the specified number of times,
+// in random order on every round, and compares the results with the
+// first target. The nodes should be started.
+func CompareBenchmarks(targets []*BenchmarkTarget, workload *BenchmarkWorkload,
+	runs int) (*BenchmarkReport, error) {
+
+	if len(targets) == 0 {
+		return nil, errors.New("no benchmark targets")
+	}
+	for _, target := range targets {
+		if target == nil || target.Node == nil {
+			return nil, errors.New("benchmark target has no node")
+		}
+	}
+	if runs < 1 {
+		return nil, fmt.Errorf("invalid number of benchmark runs: %d", runs)
+	}
+	if workload == nil || workload.Pgbench == nil && workload.Func == nil {
+		return nil, errors.New("benchmark workload is not specified")
+	}
+	if workload.Pgbench == nil && workload.Duration <= 0 {
+		return nil, fmt.Errorf("invalid duration of benchmark function: %s",
+			workload.Duration)
+	}
+
+	report := &BenchmarkReport{}
+	for _, target := range targets {
+		report.Stats = append(report.Stats, &BenchmarkStats{Target: target})
+	}
+
+	for i := 0; i < runs; i += 1 {
+		for _, idx := range rand.Perm(len(targets)) {
+			stats := report.Stats[idx]
+
+			sample, err := workload.run(stats.Target.Node)
+			if err != nil {
+				return nil, fmt.Errorf("benchmark on %s failed: %s",
+					stats.Target.Name, err)
+			}
+			stats.Samples = append(stats.Samples, sample)
+		}
+	}
+
+	var baseTPS, baseLatency []float64
+	for i, stats := range report.Stats {
+		var tps, latency []float64
+		for _, sample := range stats.Samples {
+			tps = append(tps, sample.TPS)
+			latency = append(latency,
+				float64(sample.LatencyAverage)/float64(time.Millisecond))
+		}
+
+		if i == 0 {
+			baseTPS, baseLatency = tps, latency
+		}
+		stats.TPS = summarize(tps, baseTPS)
+		stats.Latency = summarize(latency, baseLatency)
+	}
+
+	return report, nil
+}
+
+// Formats the report as a table.
+func (report *BenchmarkReport) String() string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "%-20s %12s %10s %24s %12s %10s %24s\n",
+		"target", "tps", "±95%", "tps change", "latency ms", "±95%",
+		"latency change")
+
+	for i, stats := range report.Stats {
+		tpsChange, latencyChange := "baseline", "baseline"
+		if i > 0 {
+			tpsChange = fmt.Sprintf("%+.1f%% [%+.1f%%, %+.1f%%]",
+				stats.TPS.Change*100, stats.TPS.ChangeLow*100,
+				stats.TPS.ChangeHigh*100)
+			latencyChange = fmt.Sprintf("%+.1f%% [%+.1f%%, %+.1f%%]",
+				stats.Latency.Change*100, stats.Latency.ChangeLow*100,
+				stats.Latency.ChangeHigh*100)
+		}
+
+		fmt.Fprintf(&b, "%-20s %12.1f %10.1f %24s %12.3f %10.3f %24s\n",
+			stats.Target.Name, stats.TPS.Mean, stats.TPS.CI, tpsChange,
+			stats.Latency.Mean, stats.Latency.CI, latencyChange)
+	}
+	return b.String()
+}
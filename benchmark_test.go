@@ -0,0 +1,82 @@
+package pqt
+
+import (
+	"github.com/stretchr/testify/assert"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestSummarize(t *testing.T) {
+	baseline := []float64{100, 102, 98, 100}
+	values := []float64{110, 112, 108, 110}
+
+	s := summarize(values, baseline)
+	assert.Equal(t, s.Mean, 110.0)
+	assert.InDelta(t, s.Stddev, math.Sqrt(8.0/3), 1e-9)
+	assert.InDelta(t, s.CI, 3.182*math.Sqrt(8.0/3)/2, 1e-9)
+	assert.InDelta(t, s.Change, 0.1, 1e-9)
+	assert.True(t, s.ChangeLow > 0)
+	assert.True(t, s.ChangeHigh > s.Change)
+
+	s = summarize(baseline, baseline)
+	assert.Equal(t, s.Change, 0.0)
+	assert.True(t, s.ChangeLow < 0)
+}
+
+func TestCompareBenchmarksValidation(t *testing.T) {
+	node := MakePostgresNode("master")
+	targets := []*BenchmarkTarget{{"default", node}}
+	workload := &BenchmarkWorkload{
+		Func:     func(conn *PostgresConn) error { return nil },
+		Duration: time.Second,
+	}
+
+	_, err := CompareBenchmarks(nil, workload, 3)
+	assert.NotNil(t, err)
+	_, err = CompareBenchmarks(targets, workload, 0)
+	assert.NotNil(t, err)
+	_, err = CompareBenchmarks(targets, nil, 3)
+	assert.NotNil(t, err)
+	_, err = CompareBenchmarks([]*BenchmarkTarget{{"empty", nil}}, workload, 3)
+	assert.NotNil(t, err)
+
+	workload.Duration = 0
+	_, err = CompareBenchmarks(targets, workload, 3)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "invalid duration")
+}
+
+func TestCompareBenchmarks(t *testing.T) {
+	node := MakePostgresNode("master")
+	node.Init()
+	node.Start()
+
+	node1 := MakePostgresNode("master1")
+	node1.Init()
+	node1.AppendConf("postgresql.conf", "synchronous_commit = off\n")
+	node1.Start()
+
+	workload := &BenchmarkWorkload{
+		Func: func(conn *PostgresConn) error {
+			conn.Execute("select 1")
+			return nil
+		},
+		Clients:  2,
+		Duration: 200 * time.Millisecond,
+	}
+
+	report, err := CompareBenchmarks([]*BenchmarkTarget{
+		{"default", node},
+		{"async commit", node1},
+	}, workload, 3)
+
+	assert.Nil(t, err)
+	assert.Equal(t, len(report.Stats), 2)
+	assert.Equal(t, len(report.Stats[1].Samples), 3)
+	assert.NotEqual(t, report.Stats[0].TPS.Mean, 0.0)
+	t.Log(report)
+
+	node.Stop()
+	node1.Stop()
+}
@@ -1,29 +1,68 @@
 package pqt
 
 import (
+	"context"
 	"database/sql"
+	"fmt"
+	"github.com/lib/pq"
 	"log"
 )
 
+// Connection to a node pinned to one backend.
 type PostgresConn struct {
 	node    *PostgresNode
 	dbname  string
-	conn    *sql.DB
+	db      *sql.DB
+	conn    *sql.Conn
+	pid     int
 	process *Process
+
+	listener *notificationListener
+
+	// stops watching the context of the last query, see QueryContext
+	stopWatch func()
+}
+
+// Transaction started by PostgresConn.Begin.
+type Tx struct {
+	conn *PostgresConn
+	tx   *sql.Tx
 }
 
 func MakePostgresConn(node *PostgresNode, dbname string) *PostgresConn {
-	return &PostgresConn{
+	db := node.Connect(dbname)
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		log.Panic("Can't connect to database: ", err)
+	}
+
+	pgconn := &PostgresConn{
 		node:    node,
 		dbname:  dbname,
-		conn:    node.Connect(dbname),
+		db:      db,
+		conn:    conn,
 		process: nil,
 	}
+	node.addConn(pgconn)
+	return pgconn
 }
 
 // Execute query and fetch resulting rows from node.
+// Rows should be closed before the next query on the connection.
 func (conn *PostgresConn) Fetch(sql string, params ...interface{}) *sql.Rows {
-	rows, err := conn.conn.Query(sql, params...)
+	rows, err := conn.Query(sql, params...)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return rows
+}
+
+// Same as Fetch but uses the context for query cancellation.
+func (conn *PostgresConn) FetchContext(ctx context.Context, sql string,
+	params ...interface{}) *sql.Rows {
+
+	rows, err := conn.QueryContext(ctx, sql, params...)
 	if err != nil {
 		log.Panic(err)
 	}
@@ -31,26 +70,230 @@ func (conn *PostgresConn) Fetch(sql string, params ...interface{}) *sql.Rows {
 	return rows
 }
 
+// Same as Execute but uses the context for query cancellation.
+func (conn *PostgresConn) ExecuteContext(ctx context.Context, sql string,
+	params ...interface{}) {
+
+	if err := conn.ExecContext(ctx, sql, params...); err != nil {
+		log.Panic(err)
+	}
+}
+
+// Same as Fetch but returns an error instead of panic.
+func (conn *PostgresConn) Query(sql string,
+	params ...interface{}) (*sql.Rows, error) {
+
+	return conn.QueryContext(context.Background(), sql, params...)
+}
+
+// Same as FetchContext but returns an error instead of panic.
+// When the context is done, the query is canceled by pg_cancel_backend
+// like in ExecContext, and the connection can be used after that.
+// The rows are read after the return, so the context is watched until
+// the next query on the connection.
+func (conn *PostgresConn) QueryContext(ctx context.Context, sql string,
+	params ...interface{}) (*sql.Rows, error) {
+
+	conn.stopWatching()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	stop := conn.cancelOnDone(ctx)
+	rows, err := conn.conn.QueryContext(context.Background(), sql, params...)
+	if err != nil {
+		stop()
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("%w: %s", ctx.Err(), err)
+		}
+		return nil, err
+	}
+
+	conn.stopWatch = stop
+	return rows, nil
+}
+
+// Stops watching the context of the previous query.
+func (conn *PostgresConn) stopWatching() {
+	if conn.stopWatch != nil {
+		conn.stopWatch()
+		conn.stopWatch = nil
+	}
+}
+
+// Cancels the running query with pg_cancel_backend when the context is
+// done, so the connection stays usable. The returned function stops
+// watching the context.
+func (conn *PostgresConn) cancelOnDone(ctx context.Context) func() {
+	if ctx.Done() == nil {
+		return func() {}
+	}
+
+	pid := conn.Pid()
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+		select {
+		case <-ctx.Done():
+			conn.node.signalBackend(conn.dbname, "pg_cancel_backend", pid)
+		case <-stop:
+		}
+	}()
+
+	return func() {
+		close(stop)
+		<-stopped
+	}
+}
+
+// Same as ExecuteContext but returns an error instead of panic.
+// When the context is done, the query is canceled by pg_cancel_backend
+// and the error wraps the context error. The connection can be used
+// after that.
+func (conn *PostgresConn) ExecContext(ctx context.Context, sql string,
+	params ...interface{}) error {
+
+	conn.stopWatching()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	stop := conn.cancelOnDone(ctx)
+	_, err := conn.conn.ExecContext(context.Background(), sql, params...)
+	stop()
+
+	if err != nil && ctx.Err() != nil {
+		return fmt.Errorf("%w: %s", ctx.Err(), err)
+	}
+	return err
+}
+
+// Executes query that is expected to return at most one row.
+func (conn *PostgresConn) QueryRow(sql string, params ...interface{}) *sql.Row {
+	conn.stopWatching()
+	return conn.conn.QueryRowContext(context.Background(), sql, params...)
+}
+
 // Executes query without returning any data.
 func (conn *PostgresConn) Execute(sql string, params ...interface{}) {
 	conn.Fetch(sql, params...).Close()
 }
 
+// Returns backend pid of the connection.
+func (conn *PostgresConn) Pid() int {
+	if conn.pid == 0 {
+		err := conn.QueryRow("select pg_backend_pid()").Scan(&conn.pid)
+		if err != nil {
+			log.Panic("can't get backend pid: ", err)
+		}
+	}
+
+	return conn.pid
+}
+
 // Get backend process
 func (conn *PostgresConn) Process() *Process {
 	if conn.process == nil {
-		var pid int
-		rows := conn.Fetch("select pg_backend_pid()")
-		defer rows.Close()
-		rows.Next()
-		rows.Scan(&pid)
-		conn.process = getProcessByPid(pid)
+		conn.process = getProcessByPid(conn.Pid())
 	}
 
 	return conn.process
 }
 
+// Begins a transaction. Isolation level can be sql.LevelDefault,
+// sql.LevelReadCommitted, sql.LevelRepeatableRead or sql.LevelSerializable.
+// Deferrable only has effect with serializable read only transactions.
+func (conn *PostgresConn) Begin(isolation sql.IsolationLevel,
+	readOnly bool, deferrable bool) *Tx {
+
+	conn.stopWatching()
+	tx, err := conn.conn.BeginTx(context.Background(), &sql.TxOptions{
+		Isolation: isolation,
+		ReadOnly:  readOnly,
+	})
+	if err != nil {
+		log.Panic("can't begin transaction: ", err)
+	}
+
+	if deferrable {
+		_, err = tx.Exec("set transaction deferrable")
+		if err != nil {
+			tx.Rollback()
+			log.Panic("can't begin transaction: ", err)
+		}
+	}
+
+	return &Tx{conn: conn, tx: tx}
+}
+
 // Close the connection
 func (conn *PostgresConn) Close() {
+	conn.stopWatching()
+	if conn.listener != nil {
+		conn.listener.close()
+		conn.listener = nil
+	}
 	conn.conn.Close()
+	conn.db.Close()
+	conn.node.removeConn(conn)
+}
+
+// Execute query in the transaction and fetch resulting rows.
+// Rows should be closed before the next query.
+func (tx *Tx) Fetch(sql string, params ...interface{}) *sql.Rows {
+	rows, err := tx.Query(sql, params...)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return rows
+}
+
+// Same as Fetch but returns an error instead of panic.
+func (tx *Tx) Query(sql string, params ...interface{}) (*sql.Rows, error) {
+	return tx.tx.Query(sql, params...)
+}
+
+// Executes query in the transaction that is expected to return at most
+// one row.
+func (tx *Tx) QueryRow(sql string, params ...interface{}) *sql.Row {
+	return tx.tx.QueryRow(sql, params...)
+}
+
+// Executes query in the transaction without returning any data.
+func (tx *Tx) Execute(sql string, params ...interface{}) {
+	tx.Fetch(sql, params...).Close()
+}
+
+// Commits the transaction. Returns an error if the transaction has
+// failed and was rolled back instead.
+func (tx *Tx) Commit() error {
+	return tx.tx.Commit()
+}
+
+// Rolls back the transaction.
+func (tx *Tx) Rollback() error {
+	return tx.tx.Rollback()
+}
+
+// Defines a new savepoint in the transaction.
+func (tx *Tx) Savepoint(name string) {
+	tx.Execute("savepoint " + pq.QuoteIdentifier(name))
+}
+
+// Rolls back to the savepoint.
+func (tx *Tx) RollbackTo(name string) {
+	tx.Execute("rollback to savepoint " + pq.QuoteIdentifier(name))
+}
+
+// Releases the savepoint.
+func (tx *Tx) Release(name string) {
+	tx.Execute("release savepoint " + pq.QuoteIdentifier(name))
+}
+
+// Returns the connection the transaction runs on.
+func (tx *Tx) Conn() *PostgresConn {
+	return tx.conn
 }
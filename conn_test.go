@@ -0,0 +1,57 @@
+package pqt
+
+import (
+	"database/sql"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestTransaction(t *testing.T) {
+	var count int
+	var pid int
+	var isolation string
+
+	node := MakePostgresNode("master")
+	node.Init()
+	node.Start()
+	node.Execute("postgres", "create table one(a int)")
+
+	conn := MakePostgresConn(node, "postgres")
+
+	t.Run("same backend", func(t *testing.T) {
+		tx := conn.Begin(sql.LevelRepeatableRead, false, false)
+		rows := tx.Fetch("select pg_backend_pid(), current_setting('transaction_isolation')")
+		rows.Next()
+		rows.Scan(&pid, &isolation)
+		rows.Close()
+		assert.Equal(t, pid, conn.Process().Pid)
+		assert.Equal(t, isolation, "repeatable read")
+		assert.Nil(t, tx.Commit())
+	})
+
+	t.Run("savepoint", func(t *testing.T) {
+		tx := conn.Begin(sql.LevelDefault, false, false)
+		tx.Execute("insert into one values (1)")
+		tx.Savepoint("sp")
+		tx.Execute("insert into one values (2)")
+		tx.RollbackTo("sp")
+		assert.Nil(t, tx.Commit())
+
+		rows := conn.Fetch("select count(*) from one")
+		rows.Next()
+		rows.Scan(&count)
+		rows.Close()
+		assert.Equal(t, count, 1)
+	})
+
+	t.Run("failed commit", func(t *testing.T) {
+		tx := conn.Begin(sql.LevelSerializable, true, true)
+		assert.Panics(t, func() {
+			tx.Execute("select 1/0")
+		})
+		assert.NotNil(t, tx.Commit())
+	})
+
+	conn.Close()
+	node.Stop()
+}
@@ -0,0 +1,248 @@
+package pqt
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"github.com/lib/pq"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+type CopyFormat string
+
+const (
+	CopyText   CopyFormat = "text"
+	CopyCSV    CopyFormat = "csv"
+	CopyBinary CopyFormat = "binary"
+)
+
+// Returns rows for CopyIn one by one, and io.EOF after the last row.
+type CopyRows func() ([]interface{}, error)
+
+// Makes CopyRows from a slice of rows.
+func CopyRowsFromSlice(rows [][]interface{}) CopyRows {
+	return func() ([]interface{}, error) {
+		if len(rows) == 0 {
+			return nil, io.EOF
+		}
+		row := rows[0]
+		rows = rows[1:]
+		return row, nil
+	}
+}
+
+// Makes COPY FROM STDIN statement for the table, which can be
+// qualified by a schema like "schema.table".
+func copyInStatement(table string, columns []string) string {
+	if schema, name, ok := strings.Cut(table, "."); ok {
+		return pq.CopyInSchema(schema, name, columns...)
+	}
+	return pq.CopyIn(table, columns...)
+}
+
+// Loads rows to the table using COPY FROM STDIN in a separate transaction.
+// Returns the number of copied rows.
+func (conn *PostgresConn) CopyIn(table string, columns []string,
+	rows CopyRows) (int64, error) {
+
+	var count int64
+
+	conn.stopWatching()
+	tx, err := conn.conn.BeginTx(context.Background(), nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(copyInStatement(table, columns))
+	if err != nil {
+		return 0, err
+	}
+	defer stmt.Close()
+
+	for {
+		row, err := rows()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+
+		if _, err := stmt.Exec(row...); err != nil {
+			return 0, err
+		}
+		count += 1
+	}
+
+	// flushes the data and finishes COPY
+	if _, err := stmt.Exec(); err != nil {
+		return 0, err
+	}
+	if err := stmt.Close(); err != nil {
+		return 0, err
+	}
+	return count, tx.Commit()
+}
+
+// Reads CSV records following postgres rules: unquoted empty fields are
+// NULLs and quoted empty fields are empty strings.
+type csvRecordReader struct {
+	r *bufio.Reader
+}
+
+// Returns the next record, NULLs are nil. Returns io.EOF after the last one.
+func (cr *csvRecordReader) read() ([]*string, error) {
+	var (
+		record   []*string
+		field    strings.Builder
+		quoted   bool
+		inQuotes bool
+		started  bool
+	)
+
+	emit := func() {
+		if quoted || field.Len() > 0 {
+			value := field.String()
+			record = append(record, &value)
+		} else {
+			record = append(record, nil)
+		}
+		field.Reset()
+		quoted = false
+	}
+
+	for {
+		b, err := cr.r.ReadByte()
+		if err == io.EOF {
+			if inQuotes {
+				return nil, errors.New("unterminated CSV quoted field")
+			}
+			if !started {
+				return nil, io.EOF
+			}
+			emit()
+			return record, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		started = true
+
+		if inQuotes {
+			if b != '"' {
+				field.WriteByte(b)
+			} else if next, err := cr.r.Peek(1); err == nil && next[0] == '"' {
+				cr.r.ReadByte()
+				field.WriteByte('"')
+			} else {
+				inQuotes = false
+			}
+			continue
+		}
+
+		switch b {
+		case '"':
+			inQuotes = true
+			quoted = true
+		case ',':
+			emit()
+		case '\r':
+			// \r\n line endings
+		case '\n':
+			emit()
+			return record, nil
+		default:
+			field.WriteByte(b)
+		}
+	}
+}
+
+// Loads CSV data to the table. If header is true, the first record is
+// skipped. Like in COPY with CSV format, unquoted empty fields are loaded
+// as NULLs and quoted empty fields ("") as empty strings.
+func (conn *PostgresConn) CopyInCSV(table string, columns []string,
+	r io.Reader, header bool) (int64, error) {
+
+	reader := &csvRecordReader{r: bufio.NewReader(r)}
+
+	if header {
+		if _, err := reader.read(); err != nil {
+			return 0, err
+		}
+	}
+
+	return conn.CopyIn(table, columns, func() ([]interface{}, error) {
+		record, err := reader.read()
+		if err != nil {
+			return nil, err
+		}
+
+		row := make([]interface{}, len(record))
+		for i, value := range record {
+			if value != nil {
+				row[i] = *value
+			}
+		}
+		return row, nil
+	})
+}
+
+// Writes the query result to w in specified format. lib/pq doesn't
+// support COPY TO STDOUT, so the query is copied by the connection to
+// a temporary file on the server side, which is read and removed after
+// that. The query runs in the session of the connection and sees its
+// temporary tables, the connection user should be a superuser.
+func (conn *PostgresConn) CopyOut(query string, w io.Writer,
+	format CopyFormat) error {
+
+	switch format {
+	case CopyText, CopyCSV, CopyBinary:
+	default:
+		return fmt.Errorf("unknown copy format %q", format)
+	}
+
+	f, err := ioutil.TempFile("", "pqt_copy_")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	copyCommand := fmt.Sprintf("copy (%s) to %s with (format %s)",
+		query, pq.QuoteLiteral(f.Name()), format)
+	if err := conn.ExecContext(context.Background(), copyCommand); err != nil {
+		return fmt.Errorf("copy failed: %s", err)
+	}
+
+	_, err = io.Copy(w, f)
+	return err
+}
+
+// Loads CSV file with a header line naming the columns to the table.
+// Uses the default connection.
+func (node *PostgresNode) LoadCSV(dbname string, table string,
+	path string) (int64, error) {
+
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	columns, err := csv.NewReader(f).Read()
+	if err != nil {
+		return 0, fmt.Errorf("can't read header of %s: %s", path, err)
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	return node.Conn(dbname).CopyInCSV(table, columns, f, true)
+}
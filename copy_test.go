@@ -0,0 +1,128 @@
+package pqt
+
+import (
+	"bufio"
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCopy(t *testing.T) {
+	node := MakePostgresNode("master")
+	node.Init()
+	node.Start()
+	node.Execute("postgres", "create table items(id int, name text)")
+
+	conn := MakePostgresConn(node, "postgres")
+
+	t.Run("copy in", func(t *testing.T) {
+		count, err := conn.CopyIn("items", []string{"id", "name"},
+			CopyRowsFromSlice([][]interface{}{{1, "one"}, {2, nil}}))
+		assert.Nil(t, err)
+		assert.Equal(t, count, int64(2))
+	})
+
+	t.Run("copy in csv", func(t *testing.T) {
+		count, err := conn.CopyInCSV("items", []string{"id", "name"},
+			strings.NewReader("id,name\n3,three\n4,\"four, five\"\n5,\"\"\n"), true)
+		assert.Nil(t, err)
+		assert.Equal(t, count, int64(3))
+
+		var nulls, empty int
+		conn.QueryRow("select count(*) filter (where name is null), "+
+			"count(*) filter (where name = '') from items").Scan(&nulls, &empty)
+		assert.Equal(t, 1, nulls)
+		assert.Equal(t, 1, empty)
+	})
+
+	t.Run("copy in schema", func(t *testing.T) {
+		conn.Execute("create schema fixtures")
+		conn.Execute("create table fixtures.items(id int, name text)")
+
+		count, err := conn.CopyIn("fixtures.items", []string{"id", "name"},
+			CopyRowsFromSlice([][]interface{}{{1, "one"}}))
+		assert.Nil(t, err)
+		assert.Equal(t, count, int64(1))
+	})
+
+	t.Run("load csv", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "pqt_copy_")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		path := filepath.Join(dir, "items.csv")
+		ioutil.WriteFile(path, []byte("name,id\nsix,6\n"), 0644)
+
+		count, err := node.LoadCSV("postgres", "items", path)
+		assert.Nil(t, err)
+		assert.Equal(t, count, int64(1))
+	})
+
+	t.Run("copy out", func(t *testing.T) {
+		var out bytes.Buffer
+
+		err := conn.CopyOut("select * from items order by id", &out, CopyCSV)
+		assert.Nil(t, err)
+		assert.Equal(t, out.String(),
+			"1,one\n2,\n3,three\n4,\"four, five\"\n5,\"\"\n6,six\n")
+
+		// temporary tables of the connection are visible
+		out.Reset()
+		conn.Execute("create temp table tmp as select 7 as id, 'seven' as name")
+		err = conn.CopyOut("select * from tmp", &out, CopyText)
+		assert.Nil(t, err)
+		assert.Equal(t, "7\tseven\n", out.String())
+
+		err = conn.CopyOut("select * from unknown", &out, CopyCSV)
+		assert.NotNil(t, err)
+
+		err = conn.CopyOut("select 1", &out, "xml")
+		assert.NotNil(t, err)
+	})
+
+	conn.Close()
+	node.Stop()
+}
+
+func TestCopyInStatement(t *testing.T) {
+	assert.Equal(t, `COPY "items" ("id") FROM STDIN`,
+		copyInStatement("items", []string{"id"}))
+	assert.Equal(t, `COPY "fixtures"."items" ("id") FROM STDIN`,
+		copyInStatement("fixtures.items", []string{"id"}))
+}
+
+func TestCSVRecordReader(t *testing.T) {
+	reader := &csvRecordReader{r: bufio.NewReader(strings.NewReader(
+		"a,,\"\",\"x \"\"y\"\"\"\r\n\"multi\nline\",b\nlast"))}
+
+	record, err := reader.read()
+	assert.Nil(t, err)
+	assert.Equal(t, 4, len(record))
+	assert.Equal(t, "a", *record[0])
+	assert.Nil(t, record[1])
+	assert.Equal(t, "", *record[2])
+	assert.Equal(t, `x "y"`, *record[3])
+
+	record, err = reader.read()
+	assert.Nil(t, err)
+	assert.Equal(t, "multi\nline", *record[0])
+	assert.Equal(t, "b", *record[1])
+
+	record, err = reader.read()
+	assert.Nil(t, err)
+	assert.Equal(t, "last", *record[0])
+
+	_, err = reader.read()
+	assert.Equal(t, io.EOF, err)
+
+	reader = &csvRecordReader{r: bufio.NewReader(strings.NewReader("\"open"))}
+	_, err = reader.read()
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,157 @@
+package pqt
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	corePatternFile = "/proc/sys/kernel/core_pattern"
+	coreUsesPidFile = "/proc/sys/kernel/core_uses_pid"
+)
+
+// Core dump of a crashed child process of postmaster.
+type CoreDump struct {
+	Pid       int
+	Signal    int
+	Path      string
+	Backtrace string
+	Err       error
+}
+
+func (dump *CoreDump) String() string {
+	if dump.Err != nil {
+		return fmt.Sprintf("process %d terminated by signal %d, no backtrace: %s",
+			dump.Pid, dump.Signal, dump.Err)
+	}
+	return fmt.Sprintf("process %d terminated by signal %d, core file %s:\n%s",
+		dump.Pid, dump.Signal, dump.Path, dump.Backtrace)
+}
+
+// Allows postgres processes to produce core files, should be called
+// before Start. Core dumps of crashed processes are returned by CoreDumps
+// and reported by Watch.
+func (node *PostgresNode) EnableCoreDumps() {
+	node.coreDumps = true
+}
+
+// Reads a kernel setting.
+func readKernelSetting(path string) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// Makes a glob pattern for the core file of the process from core_pattern.
+// Relative patterns are related to the working directory of the process.
+func coreFileGlob(pattern string, usesPid bool, dir string, pid int) string {
+	var (
+		glob   strings.Builder
+		hasPid bool
+	)
+
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		if c != '%' || i+1 == len(pattern) {
+			glob.WriteString(escapeGlob(string(c)))
+			continue
+		}
+
+		i++
+		switch pattern[i] {
+		case '%':
+			glob.WriteString("%")
+		case 'p', 'P':
+			hasPid = true
+			glob.WriteString(strconv.Itoa(pid))
+		default:
+			glob.WriteString("*")
+		}
+	}
+
+	result := glob.String()
+	if usesPid && !hasPid {
+		result += "." + strconv.Itoa(pid)
+	}
+	if !filepath.IsAbs(result) {
+		result = filepath.Join(escapeGlob(dir), result)
+	}
+	return result
+}
+
+var globSpecial = regexp.MustCompile(`[*?\[\\]`)
+
+func escapeGlob(s string) string {
+	return globSpecial.ReplaceAllString(s, `\$0`)
+}
+
+// Locates the core file of the crashed process. Cores handled by
+// systemd-coredump are extracted with coredumpctl.
+func (node *PostgresNode) findCoreFile(pid int) (string, error) {
+	pattern, err := readKernelSetting(corePatternFile)
+	if err != nil {
+		return "", err
+	}
+
+	if strings.HasPrefix(pattern, "|") {
+		if !strings.Contains(pattern, "systemd-coredump") {
+			return "", fmt.Errorf("core files are piped to %q", pattern[1:])
+		}
+
+		path := filepath.Join(node.baseDirectory, fmt.Sprintf("core.%d", pid))
+		out, err := exec.Command("coredumpctl", "dump", strconv.Itoa(pid),
+			"--output", path).CombinedOutput()
+		if err != nil {
+			return "", fmt.Errorf("coredumpctl error: %s: %s", err, out)
+		}
+		return path, nil
+	}
+
+	usesPid, _ := readKernelSetting(coreUsesPidFile)
+	matches, err := filepath.Glob(coreFileGlob(pattern, usesPid == "1",
+		node.dataDirectory, pid))
+	if err != nil {
+		return "", err
+	}
+	if len(matches) == 0 {
+		return "", fmt.Errorf("core file not found by pattern %q", pattern)
+	}
+	return matches[0], nil
+}
+
+// Makes a backtrace with gdb if it's available or reads it from the core.
+func coreFileBacktrace(binary, core string) (string, error) {
+	if gdb, err := exec.LookPath("gdb"); err == nil {
+		out, err := exec.Command(gdb, "-batch", "-nx", "-ex", "bt",
+			binary, core).CombinedOutput()
+		if err != nil {
+			return "", fmt.Errorf("gdb error: %s: %s", err, out)
+		}
+		return string(out), nil
+	}
+
+	return readCoreBacktrace(binary, core)
+}
+
+// Returns core dumps of the processes crashed since the node was created.
+func (node *PostgresNode) CoreDumps() []*CoreDump {
+	var dumps []*CoreDump
+
+	binary := node.Install().BinPath("postgres")
+	for _, c := range node.watchdog.getCrashes() {
+		dump := &CoreDump{Pid: c.pid, Signal: c.signal}
+		dump.Path, dump.Err = node.findCoreFile(c.pid)
+		if dump.Err == nil {
+			dump.Backtrace, dump.Err = coreFileBacktrace(binary, dump.Path)
+		}
+		dumps = append(dumps, dump)
+	}
+	return dumps
+}
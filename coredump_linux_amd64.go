@@ -0,0 +1,301 @@
+//go:build linux && amd64
+// +build linux,amd64
+
+package pqt
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	ntPrstatus = 1
+	ntFile     = 0x46494c45
+
+	// offset of pr_reg in elf_prstatus
+	prstatusRegsOffset = 112
+
+	// indexes in user_regs_struct
+	regRbp = 4
+	regRip = 16
+
+	maxBacktraceFrames = 64
+)
+
+// File mapped to memory of the crashed process.
+type coreMapping struct {
+	start  uint64
+	end    uint64
+	offset uint64
+	path   string
+}
+
+type coreFile struct {
+	file     *elf.File
+	regs     []uint64
+	mappings []coreMapping
+}
+
+type coreNote struct {
+	kind uint32
+	desc []byte
+}
+
+func align4(n uint32) uint32 {
+	return (n + 3) &^ 3
+}
+
+func parseCoreNotes(data []byte) []coreNote {
+	var notes []coreNote
+
+	for len(data) >= 12 {
+		namesz := binary.LittleEndian.Uint32(data[0:])
+		descsz := binary.LittleEndian.Uint32(data[4:])
+		kind := binary.LittleEndian.Uint32(data[8:])
+
+		start := 12 + align4(namesz)
+		end := start + align4(descsz)
+		if uint32(len(data)) < start+descsz {
+			break
+		}
+
+		notes = append(notes, coreNote{kind: kind, desc: data[start : start+descsz]})
+		if uint32(len(data)) < end {
+			break
+		}
+		data = data[end:]
+	}
+	return notes
+}
+
+func parseFileNote(desc []byte) []coreMapping {
+	if len(desc) < 16 {
+		return nil
+	}
+
+	count := binary.LittleEndian.Uint64(desc[0:])
+	pageSize := binary.LittleEndian.Uint64(desc[8:])
+	names := desc[16:]
+	if uint64(len(names)) < count*24 {
+		return nil
+	}
+
+	paths := strings.Split(string(names[count*24:]), "\x00")
+	if uint64(len(paths)) < count {
+		return nil
+	}
+
+	mappings := make([]coreMapping, count)
+	for i := range mappings {
+		entry := names[i*24:]
+		mappings[i] = coreMapping{
+			start:  binary.LittleEndian.Uint64(entry[0:]),
+			end:    binary.LittleEndian.Uint64(entry[8:]),
+			offset: binary.LittleEndian.Uint64(entry[16:]) * pageSize,
+			path:   paths[i],
+		}
+	}
+	return mappings
+}
+
+func openCoreFile(path string) (*coreFile, error) {
+	f, err := elf.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	if f.Type != elf.ET_CORE {
+		f.Close()
+		return nil, fmt.Errorf("%s is not a core file", path)
+	}
+
+	core := &coreFile{file: f}
+	for _, prog := range f.Progs {
+		if prog.Type != elf.PT_NOTE {
+			continue
+		}
+
+		data := make([]byte, prog.Filesz)
+		if _, err := prog.ReadAt(data, 0); err != nil {
+			f.Close()
+			return nil, err
+		}
+
+		for _, note := range parseCoreNotes(data) {
+			switch note.kind {
+			case ntPrstatus:
+				// the first thread is the one which got the signal
+				if core.regs == nil && len(note.desc) >= prstatusRegsOffset+27*8 {
+					regs := note.desc[prstatusRegsOffset:]
+					core.regs = make([]uint64, 27)
+					for i := range core.regs {
+						core.regs[i] = binary.LittleEndian.Uint64(regs[i*8:])
+					}
+				}
+			case ntFile:
+				core.mappings = parseFileNote(note.desc)
+			}
+		}
+	}
+
+	if core.regs == nil {
+		f.Close()
+		return nil, errors.New("registers not found in core file")
+	}
+	return core, nil
+}
+
+// Reads a word from memory of the crashed process.
+func (core *coreFile) readWord(addr uint64) (uint64, bool) {
+	for _, prog := range core.file.Progs {
+		if prog.Type != elf.PT_LOAD || addr < prog.Vaddr ||
+			addr+8 > prog.Vaddr+prog.Filesz {
+			continue
+		}
+
+		var buf [8]byte
+		if _, err := prog.ReadAt(buf[:], int64(addr-prog.Vaddr)); err != nil {
+			return 0, false
+		}
+		return binary.LittleEndian.Uint64(buf[:]), true
+	}
+	return 0, false
+}
+
+// Walks the stack by frame pointers.
+func (core *coreFile) frames() []uint64 {
+	pcs := []uint64{core.regs[regRip]}
+
+	fp := core.regs[regRbp]
+	for len(pcs) < maxBacktraceFrames && fp != 0 {
+		ret, ok := core.readWord(fp + 8)
+		if !ok || ret == 0 {
+			break
+		}
+		next, ok := core.readWord(fp)
+		if !ok {
+			break
+		}
+
+		pcs = append(pcs, ret)
+		if next <= fp {
+			break
+		}
+		fp = next
+	}
+	return pcs
+}
+
+// Returns the mapped file containing the address and its load base.
+func (core *coreFile) module(addr uint64) (string, uint64, bool) {
+	for _, m := range core.mappings {
+		if addr < m.start || addr >= m.end {
+			continue
+		}
+
+		base := m.start - m.offset
+		for _, other := range core.mappings {
+			if other.path == m.path && other.offset == 0 {
+				base = other.start
+				break
+			}
+		}
+		return m.path, base, true
+	}
+	return "", 0, false
+}
+
+// Resolves addresses of one binary or library.
+type moduleSymbols struct {
+	file  *elf.File
+	debug *DebugInformation
+	syms  []elf.Symbol
+}
+
+func openModuleSymbols(path string) (*moduleSymbols, error) {
+	f, err := elf.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	ms := &moduleSymbols{file: f}
+	if data, err := f.DWARF(); err == nil {
+		ms.debug = &DebugInformation{dwarfData: data}
+	}
+	ms.syms, _ = f.Symbols()
+	if dynsyms, err := f.DynamicSymbols(); err == nil {
+		ms.syms = append(ms.syms, dynsyms...)
+	}
+	return ms, nil
+}
+
+func (ms *moduleSymbols) lookup(addr uint64) string {
+	if ms.debug != nil {
+		funcName, fileName, line, err := ms.debug.LookupAddress(addr)
+		if err == nil {
+			if fileName != "" {
+				return fmt.Sprintf("%s () at %s:%d", funcName, fileName, line)
+			}
+			return funcName + " ()"
+		}
+	}
+
+	for _, sym := range ms.syms {
+		if elf.ST_TYPE(sym.Info) == elf.STT_FUNC &&
+			addr >= sym.Value && addr < sym.Value+sym.Size {
+			return fmt.Sprintf("%s+%#x ()", sym.Name, addr-sym.Value)
+		}
+	}
+	return "??"
+}
+
+// Reads the backtrace of the crashed thread from the core file. Only
+// frames with frame pointers can be unwound.
+func readCoreBacktrace(binary, path string) (string, error) {
+	core, err := openCoreFile(path)
+	if err != nil {
+		return "", err
+	}
+	defer core.file.Close()
+
+	var buf bytes.Buffer
+
+	modules := make(map[string]*moduleSymbols)
+	for i, pc := range core.frames() {
+		lookupPC := pc
+		if i > 0 {
+			// return address points to the next instruction
+			lookupPC--
+		}
+
+		name := "??"
+		modulePath, base, ok := core.module(lookupPC)
+		if !ok {
+			modulePath = binary
+		}
+
+		ms, seen := modules[modulePath]
+		if !seen {
+			ms, _ = openModuleSymbols(modulePath)
+			modules[modulePath] = ms
+		}
+		if ms != nil {
+			if ms.file.Type == elf.ET_DYN {
+				lookupPC -= base
+			}
+			name = ms.lookup(lookupPC)
+		}
+
+		fmt.Fprintf(&buf, "#%-2d 0x%016x in %s from %s\n", i, pc, name, modulePath)
+	}
+
+	for _, ms := range modules {
+		if ms != nil {
+			ms.file.Close()
+		}
+	}
+	return buf.String(), nil
+}
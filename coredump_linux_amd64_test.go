@@ -0,0 +1,59 @@
+//go:build linux && amd64
+// +build linux,amd64
+
+package pqt
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const crashProgram = `
+__attribute__((noinline)) void pqt_inner(int *p) { *p = 1; }
+__attribute__((noinline)) void pqt_outer(void) { pqt_inner((int *) 0); }
+int main(void) { pqt_outer(); return 0; }
+`
+
+func TestReadCoreBacktrace(t *testing.T) {
+	pattern, err := readKernelSetting(corePatternFile)
+	if err != nil || strings.HasPrefix(pattern, "|") {
+		t.Skip("core files are not written by the kernel")
+	}
+	if _, err := exec.LookPath("cc"); err != nil {
+		t.Skip("there is no C compiler")
+	}
+
+	dir, err := ioutil.TempDir("", "pqt_")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	source := filepath.Join(dir, "crash.c")
+	binary := filepath.Join(dir, "crash")
+	assert.Nil(t, ioutil.WriteFile(source, []byte(crashProgram), 0644))
+
+	out, err := exec.Command("cc", "-g", "-O0", "-fno-omit-frame-pointer",
+		"-o", binary, source).CombinedOutput()
+	assert.Nil(t, err, string(out))
+
+	cmd := exec.Command("sh", "-c", "ulimit -c unlimited; exec ./crash")
+	cmd.Dir = dir
+	assert.NotNil(t, cmd.Run())
+
+	usesPid, _ := readKernelSetting(coreUsesPidFile)
+	matches, _ := filepath.Glob(coreFileGlob(pattern, usesPid == "1", dir,
+		cmd.ProcessState.Pid()))
+	if len(matches) == 0 {
+		t.Skip("core file was not written")
+	}
+
+	bt, err := readCoreBacktrace(binary, matches[0])
+	assert.Nil(t, err)
+	assert.Contains(t, bt, "#0  ")
+	assert.Contains(t, bt, "pqt_inner")
+	assert.Contains(t, bt, "pqt_outer")
+}
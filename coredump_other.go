@@ -0,0 +1,12 @@
+//go:build !linux || !amd64
+// +build !linux !amd64
+
+package pqt
+
+import (
+	"errors"
+)
+
+func readCoreBacktrace(binary, path string) (string, error) {
+	return "", errors.New("reading backtraces from core files is not supported")
+}
@@ -0,0 +1,41 @@
+package pqt
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestCoreFileGlob(t *testing.T) {
+	assert.Equal(t, "/data/core", coreFileGlob("core", false, "/data", 5))
+	assert.Equal(t, "/data/core.5", coreFileGlob("core", true, "/data", 5))
+	assert.Equal(t, "/cores/core.*.7.*",
+		coreFileGlob("/cores/core.%e.%p.%t", true, "/data", 7))
+	assert.Equal(t, "/cores/core%", coreFileGlob("/cores/core%%", false, "/data", 7))
+}
+
+func TestCoreDumps(t *testing.T) {
+	node := MakePostgresNode("master")
+	node.Init()
+	node.EnableCoreDumps()
+	node.EnableWatchdog()
+	node.Start()
+	defer node.Stop()
+
+	conn := MakePostgresConn(node, "postgres")
+	pid := conn.Pid()
+	syscall.Kill(pid, syscall.SIGSEGV)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err := node.WaitForLog(ctx, crashPattern)
+	assert.Nil(t, err)
+
+	dumps := node.CoreDumps()
+	assert.Equal(t, 1, len(dumps))
+	assert.Equal(t, pid, dumps[0].Pid)
+	assert.Equal(t, int(syscall.SIGSEGV), dumps[0].Signal)
+	assert.NotEqual(t, 0, len(node.Problems()))
+}
@@ -1,7 +1,9 @@
 package main
 
 import (
+	"database/sql"
 	"github.com/ildus/pqt"
+	"log"
 	"sync"
 )
 
@@ -17,9 +19,11 @@ const (
 func make_queries(node *pqt.PostgresNode) {
 	for i := 0; i < queriesCount; i += 1 {
 		conn := pqt.MakePostgresConn(node, "dattest1")
-		conn.Execute("begin")
-		conn.Execute("update t set a = a + 1")
-		conn.Execute("commit")
+		tx := conn.Begin(sql.LevelReadCommitted, false, false)
+		tx.Execute("update t set a = a + 1")
+		if err := tx.Commit(); err != nil {
+			log.Panic(err)
+		}
 		conn.Close()
 	}
 	wg.Done()
@@ -0,0 +1,169 @@
+package pqt
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/lib/pq"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var preloadConfRe = regexp.MustCompile(
+	`^\s*shared_preload_libraries\s*=?\s*(?:'([^']*)'|([^\s#']+))`)
+
+// Options for extension installation.
+type ExtensionOptions struct {
+	// Directory with extension sources. If specified, the extension is
+	// built and installed with PGXS using pg_config of the node.
+	SourceDir string
+
+	// Library that should be added to shared_preload_libraries.
+	PreloadLibrary string
+
+	// Databases where the extension is created, "postgres" by default.
+	Databases []string
+
+	// Extension version for CREATE EXTENSION, default if empty.
+	Version string
+
+	// Creates extensions the extension depends on.
+	Cascade bool
+}
+
+// Runs `make install` in the extension directory with PGXS.
+func (install *PostgresInstall) makeInstall(dir string) error {
+	var out bytes.Buffer
+
+	cmd := exec.Command("make", "-C", dir, "USE_PGXS=1",
+		"PG_CONFIG="+install.PgConfig, "install")
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("make install failed: %s\n%s", err, out.String())
+	}
+	return nil
+}
+
+// Returns shared_preload_libraries from configuration files of the node.
+func (node *PostgresNode) confPreloadLibraries() string {
+	var value string
+
+	for _, name := range []string{"postgresql.conf", "postgresql.auto.conf"} {
+		f, err := os.Open(filepath.Join(node.dataDirectory, name))
+		if err != nil {
+			continue
+		}
+
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			match := preloadConfRe.FindStringSubmatch(scanner.Text())
+			if match != nil {
+				// the value can be unquoted if it's a single name
+				value = match[1] + match[2]
+			}
+		}
+		f.Close()
+	}
+	return value
+}
+
+// Adds the library to shared_preload_libraries keeping existing values.
+// Returns true if the configuration has been changed.
+func (node *PostgresNode) addPreloadLibrary(library string) bool {
+	var libraries []string
+
+	for _, item := range strings.Split(node.confPreloadLibraries(), ",") {
+		item = strings.TrimSpace(item)
+		if item == library {
+			return false
+		}
+		if item != "" {
+			libraries = append(libraries, item)
+		}
+	}
+	libraries = append(libraries, library)
+
+	// postgresql.auto.conf is read last and overrides postgresql.conf
+	node.AppendConf("postgresql.auto.conf",
+		fmt.Sprintf("\nshared_preload_libraries = '%s'\n",
+			strings.Join(libraries, ",")))
+	return true
+}
+
+// Installs the extension to the node. Optionally builds it from sources
+// and adds its library to shared_preload_libraries, restarting the node
+// if it's needed. Then creates the extension in specified databases and
+// returns its installed version.
+func (node *PostgresNode) InstallExtension(name string,
+	opts *ExtensionOptions) (string, error) {
+
+	var version string
+
+	if opts == nil {
+		opts = &ExtensionOptions{}
+	}
+
+	if node.status == INITIAL {
+		return "", errors.New("node has not been initialized")
+	}
+
+	if opts.SourceDir != "" {
+		if err := node.Install().makeInstall(opts.SourceDir); err != nil {
+			return "", err
+		}
+	}
+
+	if opts.PreloadLibrary != "" {
+		changed := node.addPreloadLibrary(opts.PreloadLibrary)
+		if changed && node.status == STARTED {
+			if _, err := node.Stop(); err != nil {
+				return "", err
+			}
+		}
+	}
+
+	// the server fails to start if the library can't be loaded
+	if node.status != STARTED {
+		if _, err := node.startServer(); err != nil {
+			return "", err
+		}
+	}
+
+	query := "create extension if not exists " + pq.QuoteIdentifier(name)
+	if opts.Version != "" {
+		query += " version " + pq.QuoteLiteral(opts.Version)
+	}
+	if opts.Cascade {
+		query += " cascade"
+	}
+
+	databases := opts.Databases
+	if len(databases) == 0 {
+		databases = []string{"postgres"}
+	}
+
+	for i, dbname := range databases {
+		conn := MakePostgresConn(node, dbname)
+		_, err := conn.conn.ExecContext(context.Background(), query)
+		if err == nil && i == 0 {
+			err = conn.conn.QueryRowContext(context.Background(),
+				"select extversion from pg_extension where extname = $1",
+				name).Scan(&version)
+		}
+		conn.Close()
+
+		if err != nil {
+			return "", fmt.Errorf("can't create extension %s in %s: %s",
+				name, dbname, err)
+		}
+	}
+
+	return version, nil
+}
@@ -0,0 +1,72 @@
+package pqt
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestInstallExtension(t *testing.T) {
+	var libraries string
+
+	node := MakePostgresNode("master")
+	node.Init()
+	node.AppendConf("postgresql.conf", "shared_preload_libraries = 'auto_explain'\n")
+	node.Start()
+
+	version, err := node.InstallExtension("pg_stat_statements", &ExtensionOptions{
+		PreloadLibrary: "pg_stat_statements",
+	})
+	assert.Nil(t, err)
+	assert.NotEqual(t, version, "")
+
+	rows := node.Fetch("postgres", "show shared_preload_libraries")
+	rows.Next()
+	rows.Scan(&libraries)
+	rows.Close()
+	assert.Equal(t, libraries, "auto_explain,pg_stat_statements")
+
+	node.Execute("postgres", "select pg_stat_statements_reset()")
+	node.Stop()
+}
+
+func TestInstallExtensionMissingLibrary(t *testing.T) {
+	node := MakePostgresNode("master")
+	node.Init()
+	node.Start()
+
+	_, err := node.InstallExtension("pqt_missing", &ExtensionOptions{
+		PreloadLibrary: "pqt_missing",
+	})
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "pqt_missing")
+	assert.Equal(t, node.status, STOPPED)
+}
+
+func TestAddPreloadLibrary(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pqt_extension_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	node := MakePostgresNode("master")
+	node.dataDirectory = dir
+	node.status = STOPPED
+
+	ioutil.WriteFile(filepath.Join(dir, "postgresql.conf"),
+		[]byte("shared_preload_libraries = auto_explain # comment\n"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "postgresql.auto.conf"), nil, 0644)
+	assert.Equal(t, "auto_explain", node.confPreloadLibraries())
+
+	assert.True(t, node.addPreloadLibrary("pg_stat_statements"))
+	assert.Equal(t, "auto_explain,pg_stat_statements",
+		node.confPreloadLibraries())
+	assert.False(t, node.addPreloadLibrary("auto_explain"))
+
+	ioutil.WriteFile(filepath.Join(dir, "postgresql.auto.conf"),
+		[]byte("shared_preload_libraries = ''\n"), 0644)
+	assert.Equal(t, "", node.confPreloadLibraries())
+}
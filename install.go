@@ -0,0 +1,93 @@
+package pqt
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os/exec"
+	"path/filepath"
+	"sync"
+)
+
+var (
+	defaultInstall     *PostgresInstall
+	defaultInstallLock sync.Mutex
+)
+
+// Postgres installation described by its pg_config.
+type PostgresInstall struct {
+	PgConfig string
+	config   map[string]string
+	version  int
+}
+
+// Makes an installation using specified pg_config binary.
+func MakePostgresInstall(pgConfig string) *PostgresInstall {
+	return &PostgresInstall{
+		PgConfig: pgConfig,
+		config:   getPgConfig(pgConfig),
+	}
+}
+
+// Returns the installation found by $PG_CONFIG or in $PATH.
+func DefaultInstall() *PostgresInstall {
+	defaultInstallLock.Lock()
+	defer defaultInstallLock.Unlock()
+
+	if defaultInstall == nil {
+		defaultInstall = MakePostgresInstall(findPgConfig())
+	}
+	return defaultInstall
+}
+
+// Returns pg_config output as a map.
+func (install *PostgresInstall) Config() map[string]string {
+	return install.config
+}
+
+// Returns directory with installation binaries.
+func (install *PostgresInstall) BinDir() string {
+	return install.config["BINDIR"]
+}
+
+// Returns full path to the binary from the installation.
+func (install *PostgresInstall) BinPath(filename string) string {
+	if path, _ := filepath.Abs(filename); path == filename {
+		return filename
+	}
+
+	return filepath.Join(install.BinDir(), filename)
+}
+
+// Makes a command for the binary from the installation.
+func (install *PostgresInstall) command(name string, args ...string) *exec.Cmd {
+	return exec.Command(install.BinPath(name), args...)
+}
+
+// Runs the binary and returns its output. The error contains stderr.
+func (install *PostgresInstall) runUtility(name string,
+	args ...string) (string, error) {
+
+	var out bytes.Buffer
+	var errout bytes.Buffer
+
+	cmd := install.command(name, args...)
+	cmd.Stdout = &out
+	cmd.Stderr = &errout
+
+	if err := cmd.Run(); err != nil {
+		return out.String(), fmt.Errorf("%s launch error: %s\n%s", name, err,
+			errout.String())
+	}
+	return out.String(), nil
+}
+
+func (install *PostgresInstall) execUtility(name string,
+	args ...string) string {
+
+	out, err := install.runUtility(name, args...)
+	if err != nil {
+		log.Panic(err)
+	}
+	return out
+}
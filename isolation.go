@@ -0,0 +1,713 @@
+package pqt
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/lib/pq"
+	"io/ioutil"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	isolationPollInterval = 10 * time.Millisecond
+
+	// Default time a step can run, like in isolationtester.
+	isolationStepTimeout = 300 * time.Second
+)
+
+// Isolation test specification in the format of postgres isolation tests
+// (see src/test/isolation/README).
+type IsolationSpec struct {
+	Setup        []string
+	Teardown     string
+	Sessions     []*IsolationSession
+	Permutations [][]string
+
+	// Time a step can run before it is canceled. If the step doesn't
+	// complete in the same time after cancelation, its backend is
+	// terminated and the run fails. PGISOLATIONTIMEOUT seconds or
+	// 300 seconds by default.
+	StepTimeout time.Duration
+}
+
+// Session of isolation test. Every session has its own connection.
+type IsolationSession struct {
+	Name     string
+	Setup    string
+	Teardown string
+	Steps    []*IsolationStep
+}
+
+// Named SQL step of a session.
+type IsolationStep struct {
+	Name    string
+	SQL     string
+	Session *IsolationSession
+}
+
+type specToken struct {
+	text     string
+	sqlBlock bool
+	quoted   bool
+}
+
+func tokenizeSpec(text string) ([]specToken, error) {
+	var tokens []specToken
+
+	for i := 0; i < len(text); {
+		c := text[i]
+		switch {
+		case c == '#':
+			for i < len(text) && text[i] != '\n' {
+				i++
+			}
+		case unicode.IsSpace(rune(c)):
+			i++
+		case c == '{':
+			end := strings.IndexByte(text[i:], '}')
+			if end < 0 {
+				return nil, errors.New("unterminated sql block")
+			}
+			tokens = append(tokens, specToken{
+				text:     strings.TrimSpace(text[i+1 : i+end]),
+				sqlBlock: true,
+			})
+			i += end + 1
+		case c == '"':
+			end := strings.IndexByte(text[i+1:], '"')
+			if end < 0 {
+				return nil, errors.New("unterminated quoted identifier")
+			}
+			tokens = append(tokens, specToken{
+				text:   text[i+1 : i+1+end],
+				quoted: true,
+			})
+			i += end + 2
+		case c == '(' || c == ')' || c == ',' || c == '*':
+			tokens = append(tokens, specToken{text: string(c)})
+			i++
+		default:
+			start := i
+			for i < len(text) && (text[i] == '_' ||
+				unicode.IsLetter(rune(text[i])) || unicode.IsDigit(rune(text[i]))) {
+				i++
+			}
+			if start == i {
+				return nil, fmt.Errorf("unexpected character %q in spec", c)
+			}
+			tokens = append(tokens, specToken{text: text[start:i]})
+		}
+	}
+	return tokens, nil
+}
+
+// Parses isolation test specification.
+func ParseIsolationSpec(text string) (*IsolationSpec, error) {
+	var session *IsolationSession
+
+	tokens, err := tokenizeSpec(text)
+	if err != nil {
+		return nil, err
+	}
+
+	spec := &IsolationSpec{}
+	steps := make(map[string]*IsolationStep)
+
+	next := func() (specToken, bool) {
+		if len(tokens) == 0 {
+			return specToken{}, false
+		}
+		token := tokens[0]
+		tokens = tokens[1:]
+		return token, true
+	}
+
+	sqlBlock := func(keyword string) (string, error) {
+		token, ok := next()
+		if !ok || !token.sqlBlock {
+			return "", fmt.Errorf("sql block expected after %s", keyword)
+		}
+		return token.text, nil
+	}
+
+	identifier := func(keyword string) (string, error) {
+		token, ok := next()
+		if !ok || token.sqlBlock || (!token.quoted && len(token.text) == 1 &&
+			strings.ContainsAny(token.text, "(),*")) {
+
+			return "", fmt.Errorf("identifier expected after %s", keyword)
+		}
+		return token.text, nil
+	}
+
+	for len(tokens) > 0 {
+		token, _ := next()
+		if token.sqlBlock || token.quoted {
+			return nil, fmt.Errorf("unexpected %q in spec", token.text)
+		}
+
+		switch token.text {
+		case "setup":
+			block, err := sqlBlock(token.text)
+			if err != nil {
+				return nil, err
+			}
+			if session != nil {
+				session.Setup = block
+			} else {
+				spec.Setup = append(spec.Setup, block)
+			}
+		case "teardown":
+			block, err := sqlBlock(token.text)
+			if err != nil {
+				return nil, err
+			}
+			if session != nil {
+				session.Teardown = block
+			} else {
+				spec.Teardown = block
+			}
+		case "session":
+			name, err := identifier(token.text)
+			if err != nil {
+				return nil, err
+			}
+			session = &IsolationSession{Name: name}
+			spec.Sessions = append(spec.Sessions, session)
+		case "step":
+			if session == nil {
+				return nil, errors.New("step outside of a session")
+			}
+			name, err := identifier(token.text)
+			if err != nil {
+				return nil, err
+			}
+			block, err := sqlBlock(token.text)
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := steps[name]; ok {
+				return nil, fmt.Errorf("duplicate step name %q", name)
+			}
+			step := &IsolationStep{Name: name, SQL: block, Session: session}
+			session.Steps = append(session.Steps, step)
+			steps[name] = step
+		case "permutation":
+			var permutation []string
+
+			session = nil
+			for len(tokens) > 0 && (tokens[0].quoted ||
+				tokens[0].text != "permutation") {
+
+				name, err := identifier(token.text)
+				if err != nil {
+					return nil, err
+				}
+				if _, ok := steps[name]; !ok {
+					return nil, fmt.Errorf("undefined step %q in permutation", name)
+				}
+				permutation = append(permutation, name)
+
+				// blocker annotations are accepted but not used
+				if len(tokens) > 0 && tokens[0].text == "(" && !tokens[0].quoted {
+					for len(tokens) > 0 && (tokens[0].text != ")" || tokens[0].quoted) {
+						next()
+					}
+					if _, ok := next(); !ok {
+						return nil, errors.New("unterminated blockers list")
+					}
+				}
+			}
+			if len(permutation) == 0 {
+				return nil, errors.New("empty permutation")
+			}
+			spec.Permutations = append(spec.Permutations, permutation)
+		default:
+			return nil, fmt.Errorf("unexpected %q in spec", token.text)
+		}
+	}
+
+	if len(spec.Sessions) == 0 {
+		return nil, errors.New("no sessions in spec")
+	}
+	return spec, nil
+}
+
+// Reads isolation test specification from the file.
+func ReadIsolationSpec(path string) (*IsolationSpec, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseIsolationSpec(string(data))
+}
+
+// Returns all interleavings of session steps keeping their order inside
+// sessions. Used when the spec has no permutations.
+func (spec *IsolationSpec) allPermutations() [][]string {
+	var result [][]string
+
+	positions := make([]int, len(spec.Sessions))
+	var permutation []string
+
+	var walk func()
+	walk = func() {
+		found := false
+		for i, session := range spec.Sessions {
+			if positions[i] >= len(session.Steps) {
+				continue
+			}
+			found = true
+			permutation = append(permutation, session.Steps[positions[i]].Name)
+			positions[i]++
+			walk()
+			positions[i]--
+			permutation = permutation[:len(permutation)-1]
+		}
+		if !found {
+			result = append(result, append([]string(nil), permutation...))
+		}
+	}
+	walk()
+	return result
+}
+
+// Step that is executing in its session.
+type runningStep struct {
+	step     *IsolationStep
+	done     chan struct{}
+	output   string
+	started  time.Time
+	canceled bool
+}
+
+type isolationRunner struct {
+	spec    *IsolationSpec
+	steps   map[string]*IsolationStep
+	conns   map[*IsolationSession]*PostgresConn
+	monitor *PostgresConn
+	running map[*IsolationSession]*runningStep
+	out     strings.Builder
+
+	blockedQuery string
+	stepTimeout  time.Duration
+
+	// backends of the sessions, only they are considered as blocking
+	pids []int64
+}
+
+// Formats an error like libpq does.
+func formatIsolationError(err error) string {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		s := fmt.Sprintf("%s:  %s\n", pqErr.Severity, pqErr.Message)
+		if pqErr.Detail != "" {
+			s += fmt.Sprintf("DETAIL:  %s\n", pqErr.Detail)
+		}
+		return s
+	}
+	return fmt.Sprintf("ERROR:  %s\n", err)
+}
+
+// Formats the result like PQprint does: values looking like numbers
+// are right aligned.
+func formatIsolationResult(columns []string, rows [][]string) string {
+	var b strings.Builder
+
+	widths := make([]int, len(columns))
+	numeric := make([]bool, len(columns))
+	for i, column := range columns {
+		widths[i] = utf8.RuneCountInString(column)
+		numeric[i] = true
+	}
+	for _, row := range rows {
+		for i, value := range row {
+			if w := utf8.RuneCountInString(value); w > widths[i] {
+				widths[i] = w
+			}
+			if strings.Trim(value, "0123456789+-.eE") != "" {
+				numeric[i] = false
+			}
+		}
+	}
+
+	pad := func(value string, i int, right bool) string {
+		spaces := strings.Repeat(" ", widths[i]-utf8.RuneCountInString(value))
+		if right {
+			return spaces + value
+		}
+		return value + spaces
+	}
+
+	for i, column := range columns {
+		if i > 0 {
+			b.WriteString("|")
+		}
+		b.WriteString(pad(column, i, false))
+	}
+	b.WriteString("\n")
+	for i := range columns {
+		if i > 0 {
+			b.WriteString("+")
+		}
+		b.WriteString(strings.Repeat("-", widths[i]))
+	}
+	b.WriteString("\n")
+	for _, row := range rows {
+		for i, value := range row {
+			if i > 0 {
+				b.WriteString("|")
+			}
+			b.WriteString(pad(value, i, numeric[i]))
+		}
+		b.WriteString("\n")
+	}
+
+	if len(rows) == 1 {
+		b.WriteString("(1 row)\n\n")
+	} else {
+		fmt.Fprintf(&b, "(%d rows)\n\n", len(rows))
+	}
+	return b.String()
+}
+
+// Executes SQL and returns formatted result of the last statement.
+func runIsolationSQL(conn *PostgresConn, query string) string {
+	var output string
+
+	rows, err := conn.Query(query)
+	if err != nil {
+		return formatIsolationError(err)
+	}
+	defer rows.Close()
+
+	for {
+		columns, err := rows.Columns()
+		if err != nil {
+			return formatIsolationError(err)
+		}
+
+		var values [][]string
+		for rows.Next() {
+			row := make([]sql.NullString, len(columns))
+			dest := make([]interface{}, len(columns))
+			for i := range row {
+				dest[i] = &row[i]
+			}
+			if err := rows.Scan(dest...); err != nil {
+				return formatIsolationError(err)
+			}
+
+			strs := make([]string, len(columns))
+			for i := range row {
+				strs[i] = row[i].String
+			}
+			values = append(values, strs)
+		}
+
+		output = ""
+		if len(columns) > 0 {
+			output = formatIsolationResult(columns, values)
+		}
+
+		if !rows.NextResultSet() {
+			break
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return formatIsolationError(err)
+	}
+	return output
+}
+
+// Checks if the session is blocked by other sessions of the spec.
+func (r *isolationRunner) blocked(session *IsolationSession) bool {
+	var blocked bool
+
+	err := r.monitor.QueryRow(r.blockedQuery, r.conns[session].Pid(),
+		pq.Array(r.pids)).Scan(&blocked)
+	if err != nil {
+		log.Panic("can't check if backend is blocked: ", err)
+	}
+	return blocked
+}
+
+// Cancels the step after the timeout and terminates its backend after
+// the doubled timeout, like isolationtester does.
+func (r *isolationRunner) checkTimeout(rs *runningStep) error {
+	elapsed := time.Since(rs.started)
+	if elapsed < r.stepTimeout {
+		return nil
+	}
+
+	pid := r.conns[rs.step.Session].Pid()
+	if !rs.canceled {
+		rs.canceled = true
+		_, err := r.monitor.conn.ExecContext(context.Background(),
+			"select pg_cancel_backend($1)", pid)
+		return err
+	}
+	if elapsed < 2*r.stepTimeout {
+		return nil
+	}
+
+	_, err := r.monitor.conn.ExecContext(context.Background(),
+		"select pg_terminate_backend($1)", pid)
+	if err != nil {
+		return err
+	}
+	select {
+	case <-rs.done:
+	case <-time.After(r.stepTimeout):
+	}
+	return fmt.Errorf("step %s timed out after %d seconds", rs.step.Name,
+		int(elapsed.Seconds()))
+}
+
+// Waits until the step completes or gets blocked.
+// Returns true if the step has completed.
+func (r *isolationRunner) wait(rs *runningStep) (bool, error) {
+	for {
+		select {
+		case <-rs.done:
+			return true, nil
+		default:
+		}
+
+		if r.blocked(rs.step.Session) {
+			// the step could have completed right after the check
+			select {
+			case <-rs.done:
+				return true, nil
+			default:
+				return false, nil
+			}
+		}
+		if err := r.checkTimeout(rs); err != nil {
+			return false, err
+		}
+		time.Sleep(isolationPollInterval)
+	}
+}
+
+// Waits until the step completes, even if it's blocked.
+func (r *isolationRunner) waitDone(rs *runningStep) error {
+	for {
+		select {
+		case <-rs.done:
+			return nil
+		case <-time.After(isolationPollInterval):
+		}
+
+		if err := r.checkTimeout(rs); err != nil {
+			return err
+		}
+	}
+}
+
+// Reports steps that were waiting and have completed.
+func (r *isolationRunner) completeWaiting(block bool) error {
+	for _, session := range r.spec.Sessions {
+		rs, ok := r.running[session]
+		if !ok {
+			continue
+		}
+
+		completed, err := r.wait(rs)
+		if err != nil {
+			return err
+		}
+		if !completed && block {
+			if err := r.waitDone(rs); err != nil {
+				return err
+			}
+			completed = true
+		}
+		if completed {
+			fmt.Fprintf(&r.out, "step %s: <... completed>\n%s", rs.step.Name, rs.output)
+			delete(r.running, session)
+		}
+	}
+	return nil
+}
+
+func (r *isolationRunner) runStep(step *IsolationStep) error {
+	// previous step of the session should be finished first
+	if rs, ok := r.running[step.Session]; ok {
+		if err := r.waitDone(rs); err != nil {
+			return err
+		}
+		fmt.Fprintf(&r.out, "step %s: <... completed>\n%s", rs.step.Name, rs.output)
+		delete(r.running, step.Session)
+	}
+
+	rs := &runningStep{
+		step:    step,
+		done:    make(chan struct{}),
+		started: time.Now(),
+	}
+	conn := r.conns[step.Session]
+	go func() {
+		rs.output = runIsolationSQL(conn, step.SQL)
+		close(rs.done)
+	}()
+
+	completed, err := r.wait(rs)
+	if err != nil {
+		return err
+	}
+	if completed {
+		fmt.Fprintf(&r.out, "step %s: %s\n%s", step.Name, step.SQL, rs.output)
+	} else {
+		fmt.Fprintf(&r.out, "step %s: %s <waiting ...>\n", step.Name, step.SQL)
+		r.running[step.Session] = rs
+	}
+
+	return r.completeWaiting(false)
+}
+
+// Runs the permutation. Returns an error if a step has timed out,
+// then the sessions are in unknown state and the run can't continue.
+func (r *isolationRunner) runPermutation(permutation []string) error {
+	fmt.Fprintf(&r.out, "\nstarting permutation: %s\n", strings.Join(permutation, " "))
+
+	for _, setup := range r.spec.Setup {
+		_, err := r.monitor.conn.ExecContext(context.Background(), setup)
+		if err != nil {
+			fmt.Fprintf(&r.out, "setup failed: %s", formatIsolationError(err))
+			return nil
+		}
+	}
+	for _, session := range r.spec.Sessions {
+		if session.Setup != "" {
+			r.out.WriteString(runIsolationSQL(r.conns[session], session.Setup))
+		}
+	}
+
+	for _, name := range permutation {
+		if err := r.runStep(r.steps[name]); err != nil {
+			return err
+		}
+	}
+	if err := r.completeWaiting(true); err != nil {
+		return err
+	}
+
+	for _, session := range r.spec.Sessions {
+		if session.Teardown != "" {
+			r.out.WriteString(runIsolationSQL(r.conns[session], session.Teardown))
+		}
+	}
+	if r.spec.Teardown != "" {
+		r.out.WriteString(runIsolationSQL(r.monitor, r.spec.Teardown))
+	}
+	return nil
+}
+
+// Terminates backends of the running steps, so their connections
+// can be closed.
+func (r *isolationRunner) terminateRunning() {
+	for session, rs := range r.running {
+		r.monitor.conn.ExecContext(context.Background(),
+			"select pg_terminate_backend($1)", r.conns[session].Pid())
+
+		select {
+		case <-rs.done:
+		case <-time.After(r.stepTimeout):
+		}
+	}
+}
+
+// Returns the step timeout of the spec.
+func (spec *IsolationSpec) stepTimeout() time.Duration {
+	if spec.StepTimeout > 0 {
+		return spec.StepTimeout
+	}
+	if seconds, err := strconv.Atoi(os.Getenv("PGISOLATIONTIMEOUT")); err == nil &&
+		seconds > 0 {
+
+		return time.Duration(seconds) * time.Second
+	}
+	return isolationStepTimeout
+}
+
+// Runs all permutations of the spec against the database and returns
+// the output in isolationtester format. Steps that get blocked are
+// reported as waiting and the next steps are run in other sessions.
+func (spec *IsolationSpec) Run(node *PostgresNode, dbname string) string {
+	r := &isolationRunner{
+		spec:    spec,
+		steps:   make(map[string]*IsolationStep),
+		conns:   make(map[*IsolationSession]*PostgresConn),
+		running: make(map[*IsolationSession]*runningStep),
+		monitor: MakePostgresConn(node, dbname),
+
+		blockedQuery: blockedByQuery(node.Version()),
+		stepTimeout:  spec.stepTimeout(),
+	}
+	defer r.monitor.Close()
+
+	for _, session := range spec.Sessions {
+		conn := MakePostgresConn(node, dbname)
+		defer conn.Close()
+
+		// remember backend pid before any step is started
+		r.pids = append(r.pids, int64(conn.Pid()))
+
+		r.conns[session] = conn
+		for _, step := range session.Steps {
+			r.steps[step.Name] = step
+		}
+	}
+
+	fmt.Fprintf(&r.out, "Parsed test spec with %d sessions\n", len(spec.Sessions))
+
+	permutations := spec.Permutations
+	if len(permutations) == 0 {
+		permutations = spec.allPermutations()
+	}
+	for _, permutation := range permutations {
+		if err := r.runPermutation(permutation); err != nil {
+			fmt.Fprintf(&r.out, "%s\n", err)
+			r.terminateRunning()
+			break
+		}
+	}
+
+	return r.out.String()
+}
+
+// Runs isolation test spec and compares its output with expected file.
+// Fails the test with unified diff if the output differs.
+func RunIsolationTest(t *testing.T, node *PostgresNode, dbname string,
+	specFile string, expectedFile string) bool {
+
+	t.Helper()
+
+	spec, err := ReadIsolationSpec(specFile)
+	if err != nil {
+		t.Fatalf("can't read spec %s: %s", specFile, err)
+	}
+
+	expected, err := ioutil.ReadFile(expectedFile)
+	if err != nil {
+		t.Fatalf("can't read expected output: %s", err)
+	}
+
+	output := spec.Run(node, dbname)
+	if diff := unifiedDiff(expectedFile, "result", string(expected), output); diff != "" {
+		t.Error(diff)
+		return false
+	}
+	return true
+}
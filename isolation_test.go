@@ -0,0 +1,118 @@
+package pqt
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+const isolationSpec = `
+# simple lock conflict
+setup
+{
+  create table t(id int primary key, v int);
+  insert into t values (1, 0);
+}
+
+teardown { drop table t; }
+
+session s1
+setup		{ begin; }
+step s1u	{ update t set v = v + 1 where id = 1; }
+step s1c	{ commit; }
+
+session "s2"
+step s2u	{ update t set v = v + 10 where id = 1; }
+step s2s	{ select v from t; }
+
+permutation s1u s2u s1c s2s
+permutation "s1u" s1c(*) s2u s2s
+`
+
+func TestParseIsolationSpec(t *testing.T) {
+	spec, err := ParseIsolationSpec(isolationSpec)
+	assert.Nil(t, err)
+	assert.Equal(t, len(spec.Setup), 1)
+	assert.Equal(t, spec.Teardown, "drop table t;")
+	assert.Equal(t, len(spec.Sessions), 2)
+	assert.Equal(t, spec.Sessions[0].Setup, "begin;")
+	assert.Equal(t, spec.Sessions[1].Name, "s2")
+	assert.Equal(t, spec.Sessions[1].Steps[1].SQL, "select v from t;")
+	assert.Equal(t, spec.Permutations, [][]string{
+		{"s1u", "s2u", "s1c", "s2s"},
+		{"s1u", "s1c", "s2u", "s2s"},
+	})
+
+	spec.Permutations = nil
+	assert.Equal(t, len(spec.allPermutations()), 6)
+
+	_, err = ParseIsolationSpec("session s1\nstep s1a { select 1; }\npermutation s1b")
+	assert.NotNil(t, err)
+	_, err = ParseIsolationSpec("setup { select 1;")
+	assert.NotNil(t, err)
+}
+
+func TestFormatIsolationResult(t *testing.T) {
+	expected := "id|name\n--+----\n 1|one \n10|ab  \n(2 rows)\n\n"
+	assert.Equal(t, expected, formatIsolationResult([]string{"id", "name"},
+		[][]string{{"1", "one"}, {"10", "ab"}}))
+}
+
+func TestIsolation(t *testing.T) {
+	expected := `Parsed test spec with 2 sessions
+
+starting permutation: s1u s2u s1c s2s
+step s1u: update t set v = v + 1 where id = 1;
+step s2u: update t set v = v + 10 where id = 1; <waiting ...>
+step s1c: commit;
+step s2u: <... completed>
+step s2s: select v from t;
+v 
+--
+11
+(1 row)
+
+
+starting permutation: s1u s1c s2u s2s
+step s1u: update t set v = v + 1 where id = 1;
+step s1c: commit;
+step s2u: update t set v = v + 10 where id = 1;
+step s2s: select v from t;
+v 
+--
+11
+(1 row)
+
+`
+
+	node := MakePostgresNode("master")
+	node.Init()
+	node.Start()
+
+	spec, err := ParseIsolationSpec(isolationSpec)
+	assert.Nil(t, err)
+	assert.Equal(t, expected, spec.Run(node, "postgres"))
+
+	node.Stop()
+}
+
+func TestIsolationStepTimeout(t *testing.T) {
+	node := MakePostgresNode("master")
+	node.Init()
+	node.Start()
+	defer node.Stop()
+
+	spec, err := ParseIsolationSpec(`
+session s1
+step sleep { select pg_sleep(60); }
+step one { select 1 as a; }
+`)
+	assert.Nil(t, err)
+	spec.StepTimeout = 200 * time.Millisecond
+
+	started := time.Now()
+	out := spec.Run(node, "postgres")
+	assert.True(t, time.Since(started) < 10*time.Second)
+	assert.Contains(t, out, "canceling statement due to user request")
+	assert.Contains(t, out, "step one: select 1 as a;")
+}
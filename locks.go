@@ -0,0 +1,96 @@
+package pqt
+
+import (
+	"context"
+)
+
+// Returns query checking if the backend waits for a lock or
+// a safe snapshot, depending on server version.
+func blockedQuery(version int) string {
+	if version < 90600 {
+		return "select exists(select 1 from pg_locks where pid = $1 and not granted)"
+	}
+
+	query := "select cardinality(pg_blocking_pids($1)) > 0"
+	if version >= 100000 {
+		query += " or cardinality(pg_safe_snapshot_blocking_pids($1)) > 0"
+	}
+	return query
+}
+
+// Returns query checking if the backend ($1) is blocked by one of
+// the backends in the array ($2), like isolationtester does. Before 9.6
+// any lock held by them on the same object is considered blocking.
+func blockedByQuery(version int) string {
+	if version < 90600 {
+		return `select exists(select 1 from pg_locks w join pg_locks h
+			on (h.locktype, h.database, h.relation, h.page, h.tuple,
+				h.virtualxid, h.transactionid, h.classid, h.objid,
+				h.objsubid) is not distinct from
+			(w.locktype, w.database, w.relation, w.page, w.tuple,
+				w.virtualxid, w.transactionid, w.classid, w.objid,
+				w.objsubid)
+			where w.pid = $1 and not w.granted and h.granted
+				and h.pid = any($2::int[]))`
+	}
+
+	query := "select pg_blocking_pids($1) && $2::int[]"
+	if version >= 100000 {
+		query += " or pg_safe_snapshot_blocking_pids($1) && $2::int[]"
+	}
+	return query
+}
+
+// Checks if the backend is blocked using monitor connection.
+func backendBlocked(monitor *PostgresConn, pid int, query string) (bool, error) {
+	var blocked bool
+
+	err := monitor.QueryRow(query, pid).Scan(&blocked)
+	return blocked, err
+}
+
+// Waits until the backend of the connection is blocked by a lock held
+// by another backend. The connection should be executing a query
+// asynchronously, otherwise it waits until the context is done.
+func (conn *PostgresConn) WaitUntilBlocked(ctx context.Context) error {
+	pid := conn.Pid()
+
+	monitor := MakePostgresConn(conn.node, conn.dbname)
+	defer monitor.Close()
+
+	query := blockedQuery(conn.node.Version())
+	return poll(ctx, func() (bool, error) {
+		return backendBlocked(monitor, pid, query)
+	})
+}
+
+// Waits until some backend waits for a lock on the relation of the
+// database in specified mode (like "AccessExclusiveLock", any mode if
+// empty) and returns its pid.
+func (node *PostgresNode) WaitForLock(ctx context.Context, dbname string,
+	relation string, mode string) (int, error) {
+
+	var pid int
+
+	monitor := MakePostgresConn(node, dbname)
+	defer monitor.Close()
+
+	err := poll(ctx, func() (bool, error) {
+		rows, err := monitor.Query(`select pid from pg_locks
+			where not granted and relation = $1::regclass
+				and database = (select oid from pg_database
+					where datname = current_database())
+				and ($2 = '' or mode = $2)
+			limit 1`, relation, mode)
+		if err != nil {
+			return false, err
+		}
+		defer rows.Close()
+
+		if rows.Next() {
+			return true, rows.Scan(&pid)
+		}
+		return false, rows.Err()
+	})
+	return pid, err
+}
@@ -0,0 +1,63 @@
+package pqt
+
+import (
+	"context"
+	"database/sql"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestLocks(t *testing.T) {
+	node := MakePostgresNode("master")
+	node.Init()
+	node.Start()
+	node.Execute("postgres", "create table t(a int)")
+	node.Execute("postgres", "create database other")
+	node.Execute("other", "create table t(a int)")
+
+	conn1 := MakePostgresConn(node, "postgres")
+	conn2 := MakePostgresConn(node, "postgres")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tx := conn1.Begin(sql.LevelDefault, false, false)
+	tx.Execute("lock table t in access exclusive mode")
+
+	res := conn2.ExecuteAsync(ctx, "lock table t in access exclusive mode")
+	blocked, err := res.WaitUntilBlocked(ctx)
+	assert.Nil(t, err)
+	assert.True(t, blocked)
+	assert.Nil(t, conn2.WaitUntilBlocked(ctx))
+
+	pid, err := node.WaitForLock(ctx, "postgres", "t", "AccessExclusiveLock")
+	assert.Nil(t, err)
+	assert.Equal(t, pid, conn2.Pid())
+
+	// locks in other databases are ignored
+	shortCtx, shortCancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer shortCancel()
+	_, err = node.WaitForLock(shortCtx, "other", "t", "")
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	assert.Nil(t, tx.Commit())
+	assert.Nil(t, res.Wait())
+
+	res = conn2.ExecuteAsync(ctx, "select 1")
+	blocked, err = res.WaitUntilBlocked(ctx)
+	assert.Nil(t, err)
+	assert.False(t, blocked)
+
+	conn1.Close()
+	conn2.Close()
+	node.Stop()
+}
+
+func TestBlockedByQuery(t *testing.T) {
+	for _, version := range []int{90500, 90600, 100000, 170000} {
+		query := blockedByQuery(version)
+		assert.Contains(t, query, "$1")
+		assert.Contains(t, query, "$2::int[]")
+	}
+}
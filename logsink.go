@@ -0,0 +1,117 @@
+package pqt
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"testing"
+)
+
+var (
+	pqtLogFile = flag.String("pqt-log", "", "Collect logs to one place")
+
+	defaultSinkOnce sync.Once
+	defaultSink     LogSink
+)
+
+// Receives server log lines of a node.
+type LogSink interface {
+	Log(line string)
+}
+
+type writerLogSink struct {
+	mutex sync.Mutex
+	w     io.Writer
+}
+
+func (s *writerLogSink) Log(line string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	fmt.Fprintln(s.w, line)
+}
+
+// Returns a sink writing lines to w. Writes are serialized, so the sink
+// can be shared by several nodes.
+func WriterLogSink(w io.Writer) LogSink {
+	return &writerLogSink{w: w}
+}
+
+type testLogSink struct {
+	mutex    sync.Mutex
+	t        testing.TB
+	finished bool
+}
+
+func (s *testLogSink) Log(line string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// logging after the test has completed panics
+	if !s.finished {
+		s.t.Log(line)
+	}
+}
+
+// Returns a sink writing lines to the test log. Lines read after the test
+// cleanup are dropped.
+func TestLogSink(t testing.TB) LogSink {
+	s := &testLogSink{t: t}
+	t.Cleanup(func() {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+
+		s.finished = true
+	})
+	return s
+}
+
+type discardLogSink struct{}
+
+func (discardLogSink) Log(line string) {}
+
+// Sink ignoring all lines.
+var DiscardLogSink LogSink = discardLogSink{}
+
+// Returns the sink used by new nodes. Lines are written to the file set by
+// PQT_LOG environment variable or -pqt-log flag (if the flags were parsed
+// by the program), otherwise to stderr.
+func getDefaultLogSink() LogSink {
+	defaultSinkOnce.Do(func() {
+		path := os.Getenv("PQT_LOG")
+		if flag.Parsed() && *pqtLogFile != "" {
+			path = *pqtLogFile
+		}
+
+		if path == "" {
+			defaultSink = WriterLogSink(os.Stderr)
+			return
+		}
+
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC,
+			os.ModePerm)
+		if err != nil {
+			log.Panic("can't open file for logging: ", err)
+		}
+		defaultSink = WriterLogSink(f)
+	})
+	return defaultSink
+}
+
+// Sets the sink receiving server log lines of the node.
+func (node *PostgresNode) SetLogSink(sink LogSink) {
+	node.mutex.Lock()
+	defer node.mutex.Unlock()
+
+	node.logSink = sink
+}
+
+func (node *PostgresNode) getLogSink() LogSink {
+	node.mutex.Lock()
+	defer node.mutex.Unlock()
+
+	return node.logSink
+}
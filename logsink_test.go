@@ -0,0 +1,69 @@
+package pqt
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriterLogSink(t *testing.T) {
+	var buf bytes.Buffer
+
+	sink := WriterLogSink(&buf)
+	sink.Log("first")
+	sink.Log("second")
+	DiscardLogSink.Log("third")
+	assert.Equal(t, "first\nsecond\n", buf.String())
+}
+
+func TestTestLogSink(t *testing.T) {
+	var sink LogSink
+
+	t.Run("inner", func(t *testing.T) {
+		sink = TestLogSink(t)
+		sink.Log("line")
+	})
+
+	// must not panic after the test has completed
+	sink.Log("late line")
+}
+
+func TestLogTail(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pqt_")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "test.log")
+	assert.Nil(t, ioutil.WriteFile(filename, []byte("skipped\n"), 0644))
+
+	var lines []string
+	lt, err := startLogTail(filename, fileSize(filename), func(line string) {
+		lines = append(lines, line)
+	})
+	assert.Nil(t, err)
+
+	f, err := os.OpenFile(filename, os.O_APPEND|os.O_WRONLY, 0644)
+	assert.Nil(t, err)
+	f.WriteString("first\nsecond\n")
+	f.Close()
+
+	lt.stop()
+	assert.Equal(t, []string{"first", "second"}, lines)
+}
+
+func TestDestroy(t *testing.T) {
+	node := MakePostgresNode("master")
+	node.SetLogSink(TestLogSink(t))
+	node.Init()
+	node.Start()
+
+	dir := node.baseDirectory
+	assert.Nil(t, node.Destroy())
+
+	_, err := os.Stat(dir)
+	assert.True(t, os.IsNotExist(err))
+	assert.Equal(t, 0, len(node.logTails))
+}
@@ -4,10 +4,10 @@ package pqt
 import (
 	"database/sql"
 	"errors"
-	"flag"
 	"fmt"
 	"github.com/hpcloud/tail"
 	_ "github.com/lib/pq"
+	"io"
 	"io/ioutil"
 	"log"
 	"os"
@@ -15,6 +15,7 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 )
 
 const (
@@ -23,11 +24,6 @@ const (
 	STOPPED int = iota
 )
 
-var (
-	pqtLogSetUp bool = false
-	pqtLogFile       = flag.String("pqt-log", "", "Collect logs to one place")
-)
-
 // postmaster node
 type PostgresNode struct {
 	name string
@@ -39,49 +35,145 @@ type PostgresNode struct {
 	dataDirectory string
 	pgLogFile     string
 	status        int
+	install       *PostgresInstall
+	serverVersion int
 
+	mutex          sync.Mutex
 	connections    []*sql.DB
+	conns          []*PostgresConn
 	lastConnection *PostgresConn
+
+	serverLog     serverLog
+	logFormat     LogFormat
+	structuredLog structuredLog
+	watchdog      watchdog
+	logSink       LogSink
+	logTails      []*logTail
+	coreDumps     bool
+}
+
+// Tail of a log file, handled by a separate goroutine.
+type logTail struct {
+	tail *tail.Tail
+	done chan struct{}
 }
 
-// Reads new lines from postgres logs.
-func tailLog(node *PostgresNode, filename string) {
-	t, err := tail.TailFile(filename, tail.Config{Follow: true})
+// Starts reading lines of the file from the offset.
+func startLogTail(filename string, offset int64,
+	handle func(line string)) (*logTail, error) {
+
+	t, err := tail.TailFile(filename, tail.Config{
+		Follow:   true,
+		Location: &tail.SeekInfo{Offset: offset, Whence: io.SeekStart},
+		Logger:   tail.DiscardingLogger,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	lt := &logTail{tail: t, done: make(chan struct{})}
+	go func() {
+		defer close(lt.done)
+		for line := range t.Lines {
+			handle(line.Text)
+		}
+	}()
+	return lt, nil
+}
+
+// Reads the rest of the file and stops the tail.
+func (lt *logTail) stop() {
+	lt.tail.StopAtEOF()
+	<-lt.done
+	lt.tail.Cleanup()
+}
+
+// Starts reading new lines of the server log.
+func (node *PostgresNode) tailLog(filename string, offset int64) {
+	lt, err := startLogTail(filename, offset, func(line string) {
+		node.getLogSink().Log(fmt.Sprintf("%s: %s", node.name, line))
+		node.serverLog.append(offset, line)
+		node.watchdog.check(line)
+
+		// the tail strips line endings
+		offset += int64(len(line)) + 1
+	})
 	if err != nil {
 		log.Print("can't tail file: ", filename)
+		return
 	}
+	node.addLogTail(lt)
+}
 
-	for line := range t.Lines {
-		flags := log.Flags()
-		log.SetFlags(0)
-		log.Printf("%s: %s", node.name, line.Text)
-		log.SetFlags(flags)
+func (node *PostgresNode) addLogTail(lt *logTail) {
+	node.mutex.Lock()
+	defer node.mutex.Unlock()
 
-		if node.status == STOPPED {
-			break
-		}
+	node.logTails = append(node.logTails, lt)
+}
+
+// Stops all log tails of the node.
+func (node *PostgresNode) stopLogTails() {
+	node.mutex.Lock()
+	tails := node.logTails
+	node.logTails = nil
+	node.mutex.Unlock()
+
+	for _, lt := range tails {
+		lt.stop()
 	}
 }
 
-// Creates a new connection to node.
-func (node *PostgresNode) Connect(dbname string) *sql.DB {
-	conninfo := fmt.Sprintf("postgres://%s@%s:%d/%s?sslmode=disable",
+// Returns connection string to the database.
+func (node *PostgresNode) ConnString(dbname string) string {
+	return fmt.Sprintf("postgres://%s@%s:%d/%s?sslmode=disable",
 		node.user, node.host, node.Port, dbname)
+}
 
-	db, err := sql.Open("postgres", conninfo)
+// Creates a new connection to node.
+func (node *PostgresNode) Connect(dbname string) *sql.DB {
+	db, err := sql.Open("postgres", node.ConnString(dbname))
 	if err != nil {
 		log.Panic("Can't connect to database: ", err)
 	}
 
+	node.mutex.Lock()
 	node.connections = append(node.connections, db)
+	node.mutex.Unlock()
 	return db
 }
 
-// Execute query and fetch resulting rows from node.
-// Uses the default connection to postgres database.
-func (node *PostgresNode) Fetch(dbname string, sql string,
-	params ...interface{}) *sql.Rows {
+func (node *PostgresNode) addConn(conn *PostgresConn) {
+	node.mutex.Lock()
+	defer node.mutex.Unlock()
+
+	node.conns = append(node.conns, conn)
+}
+
+// Forgets closed connection.
+func (node *PostgresNode) removeConn(conn *PostgresConn) {
+	node.mutex.Lock()
+	defer node.mutex.Unlock()
 
+	for i := range node.conns {
+		if node.conns[i] == conn {
+			node.conns = append(node.conns[:i], node.conns[i+1:]...)
+			break
+		}
+	}
+	for i := range node.connections {
+		if node.connections[i] == conn.db {
+			node.connections = append(node.connections[:i],
+				node.connections[i+1:]...)
+			break
+		}
+	}
+}
+
+// Returns the default connection to the database, which is used by
+// Fetch and Execute. The previous default connection is closed
+// if it was made to another database.
+func (node *PostgresNode) Conn(dbname string) *PostgresConn {
 	if node.lastConnection != nil &&
 		node.lastConnection.dbname != dbname {
 
@@ -93,7 +185,31 @@ func (node *PostgresNode) Fetch(dbname string, sql string,
 		node.lastConnection = MakePostgresConn(node, dbname)
 	}
 
-	return node.lastConnection.Fetch(sql, params...)
+	return node.lastConnection
+}
+
+// Execute query and fetch resulting rows from node.
+// Uses the default connection to postgres database.
+func (node *PostgresNode) Fetch(dbname string, sql string,
+	params ...interface{}) *sql.Rows {
+
+	return node.Conn(dbname).Fetch(sql, params...)
+}
+
+// Executes query that is expected to return at most one row.
+// Uses the default connection.
+func (node *PostgresNode) QueryRow(dbname string, sql string,
+	params ...interface{}) *sql.Row {
+
+	return node.Conn(dbname).QueryRow(sql, params...)
+}
+
+// Executes query and returns the result as text table.
+// Uses the default connection.
+func (node *PostgresNode) QueryTable(dbname string, sql string,
+	params ...interface{}) (*Table, error) {
+
+	return QueryTable(node.Conn(dbname), sql, params...)
 }
 
 // Executes query without returning any data.
@@ -115,27 +231,79 @@ func (node *PostgresNode) Start(params ...string) (string, error) {
 		return "", errors.New("node has not been initialized")
 	}
 
-	if node.pgLogFile == "" {
-		dir := filepath.Join(node.baseDirectory, "logs")
-		os.Mkdir(dir, os.ModePerm)
-		node.pgLogFile = filepath.Join(dir, "postgresql.log")
+	res, err := node.startServer(params...)
+	if err != nil {
+		log.Panic(err)
 	}
+	return res, nil
+}
 
+// Runs pg_ctl start and starts reading the logs. If the server fails
+// to start, the error contains last lines of the server log.
+func (node *PostgresNode) startServer(params ...string) (string, error) {
 	args := []string{
 		"-D", node.dataDirectory,
-		"-l", node.pgLogFile,
+		"-l", node.logFile(),
 		"-w", // wait
 		"start",
 	}
+	if node.coreDumps {
+		args = append(args, "-c")
+	}
 	args = append(args, params...)
 
-	res := execUtility("pg_ctl", args...)
+	// only new lines are read from the logs
+	offset := fileSize(node.pgLogFile)
+	structuredOffset := fileSize(node.structuredLogFile())
+
+	res, err := node.Install().runUtility("pg_ctl", args...)
+	if err != nil {
+		return res, fmt.Errorf("%s: %s\nlast lines of the server log:\n%s",
+			node.name, err, strings.Join(readLastLines(node.pgLogFile, offset,
+				logTailLines), "\n"))
+	}
+
+	node.mutex.Lock()
+	node.serverVersion = 0
+	node.mutex.Unlock()
+
 	node.status = STARTED
-	go tailLog(node, node.pgLogFile)
+	node.tailLog(node.pgLogFile, offset)
+	if node.logFormat != "" {
+		node.tailStructuredLog(node.structuredLogFile(), structuredOffset)
+	}
 
 	return res, nil
 }
 
+// Returns the server log file, creating logs directory if needed.
+func (node *PostgresNode) logFile() string {
+	if node.pgLogFile == "" {
+		dir := filepath.Join(node.baseDirectory, "logs")
+		os.Mkdir(dir, os.ModePerm)
+		node.pgLogFile = filepath.Join(dir, "postgresql.log")
+	}
+	return node.pgLogFile
+}
+
+// Closes all connections made to the node.
+func (node *PostgresNode) CloseConnections() {
+	node.mutex.Lock()
+	conns := node.conns
+	connections := node.connections
+	node.conns = nil
+	node.connections = nil
+	node.lastConnection = nil
+	node.mutex.Unlock()
+
+	for i := range conns {
+		conns[i].Close()
+	}
+	for i := range connections {
+		connections[i].Close()
+	}
+}
+
 // Stops a postgres node.
 func (node *PostgresNode) Stop(params ...string) (string, error) {
 	if node.status != STARTED {
@@ -150,17 +318,49 @@ func (node *PostgresNode) Stop(params ...string) (string, error) {
 	}
 	args = append(args, params...)
 
-	for i := range node.connections {
-		node.connections[i].Close()
-	}
-	node.connections = nil
-
-	res := execUtility("pg_ctl", args...)
+	node.CloseConnections()
+	res := node.execUtility("pg_ctl", args...)
 	node.status = STOPPED
+	node.stopLogTails()
 
 	return res, nil
 }
 
+// Stops the node if it was started, stops reading its logs and removes
+// its directory.
+func (node *PostgresNode) Destroy() error {
+	if node.status == STARTED {
+		if _, err := node.Stop(); err != nil {
+			return err
+		}
+	}
+	node.stopLogTails()
+	node.serverLog.reset()
+	node.structuredLog.reset()
+
+	if node.baseDirectory != "" {
+		if err := os.RemoveAll(node.baseDirectory); err != nil {
+			return err
+		}
+	}
+
+	node.baseDirectory = ""
+	node.dataDirectory = ""
+	node.pgLogFile = ""
+	node.status = INITIAL
+	return nil
+}
+
+// Restarts a postgres node.
+func (node *PostgresNode) Restart(params ...string) (string, error) {
+	res, err := node.Stop()
+	if err != nil {
+		return res, err
+	}
+
+	return node.Start(params...)
+}
+
 // Initializes a new postgres node.
 // Creates directories for logs and data, and writes
 // a default configuration.
@@ -189,7 +389,7 @@ func (node *PostgresNode) Init(params ...string) (string, error) {
 	}
 	args = append(args, params...)
 
-	res := execUtility("initdb", args...)
+	res := node.execUtility("initdb", args...)
 	node.initDefaultConf()
 	node.status = STOPPED
 	return res, nil
@@ -248,30 +448,29 @@ func (node *PostgresNode) Pid() int {
 	return pid
 }
 
-// Returns Process instance for postmaster.
+// Returns the installation used by the node.
+func (node *PostgresNode) Install() *PostgresInstall {
+	if node.install == nil {
+		return DefaultInstall()
+	}
+	return node.install
+}
+
+func (node *PostgresNode) execUtility(name string, args ...string) string {
+	return node.Install().execUtility(name, args...)
+}
+
+// Returns Process instance for postmaster, or nil if it is not running.
 func (node *PostgresNode) GetProcess() (result *Process) {
 	result = getProcessByPid(node.Pid())
-	result.Type = Postmaster
+	if result != nil {
+		result.Type = Postmaster
+	}
 	return result
 }
 
 // Makes a new postgres node using specified name.
 func MakePostgresNode(name string) *PostgresNode {
-	if !pqtLogSetUp {
-		pqtLogSetUp = true
-		flag.Parse()
-
-		if *pqtLogFile != "" {
-			f, err := os.OpenFile(*pqtLogFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC,
-				os.ModePerm)
-
-			if err != nil {
-				log.Panic("can't open file for logging")
-			}
-			log.SetOutput(f)
-		}
-	}
-
 	curUser, err := user.Current()
 	if err != nil {
 		log.Panic("can't get current user's username")
@@ -284,5 +483,15 @@ func MakePostgresNode(name string) *PostgresNode {
 		lastConnection: nil,
 		status:         INITIAL,
 		user:           curUser.Username,
+		logSink:        getDefaultLogSink(),
 	}
 }
+
+// Makes a new postgres node that uses binaries from specified installation.
+func MakePostgresNodeWithInstall(name string,
+	install *PostgresInstall) *PostgresNode {
+
+	node := MakePostgresNode(name)
+	node.install = install
+	return node
+}
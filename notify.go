@@ -0,0 +1,122 @@
+package pqt
+
+import (
+	"github.com/lib/pq"
+	"log"
+	"sync"
+	"time"
+)
+
+const notificationBuffer = 1024
+
+// Notification received by LISTEN.
+type Notification struct {
+	Channel string
+	Payload string
+	Pid     int
+}
+
+// Dedicated connection receiving notifications for PostgresConn.
+type notificationListener struct {
+	mutex       sync.Mutex
+	listener    *pq.Listener
+	subscribers map[string][]chan *Notification
+}
+
+func (nl *notificationListener) dispatch() {
+	for n := range nl.listener.Notify {
+		// nil is sent after reconnection
+		if n == nil {
+			continue
+		}
+
+		notification := &Notification{
+			Channel: n.Channel,
+			Payload: n.Extra,
+			Pid:     n.BePid,
+		}
+
+		// subscribers are served under the lock, so their channels
+		// can't be closed meanwhile
+		nl.mutex.Lock()
+		for _, ch := range nl.subscribers[n.Channel] {
+			select {
+			case ch <- notification:
+			default:
+				log.Printf("notification buffer for channel %s is full", n.Channel)
+			}
+		}
+		nl.mutex.Unlock()
+	}
+}
+
+func (nl *notificationListener) close() {
+	nl.listener.Close()
+
+	nl.mutex.Lock()
+	defer nl.mutex.Unlock()
+
+	for channel, subscribers := range nl.subscribers {
+		for _, ch := range subscribers {
+			close(ch)
+		}
+		delete(nl.subscribers, channel)
+	}
+}
+
+// Starts listening for notifications on the channel. Notifications are
+// received by a dedicated connection and sent to the returned Go channel,
+// which is closed by Unlisten or Close. If the channel buffer is full,
+// new notifications are dropped.
+func (conn *PostgresConn) Listen(channel string) <-chan *Notification {
+	if conn.listener == nil {
+		listener := pq.NewListener(conn.node.ConnString(conn.dbname),
+			10*time.Millisecond, time.Second, nil)
+
+		conn.listener = &notificationListener{
+			listener:    listener,
+			subscribers: make(map[string][]chan *Notification),
+		}
+		go conn.listener.dispatch()
+	}
+
+	nl := conn.listener
+	ch := make(chan *Notification, notificationBuffer)
+
+	nl.mutex.Lock()
+	nl.subscribers[channel] = append(nl.subscribers[channel], ch)
+	nl.mutex.Unlock()
+
+	err := nl.listener.Listen(channel)
+	if err != nil && err != pq.ErrChannelAlreadyOpen {
+		log.Panic("can't listen to channel: ", err)
+	}
+
+	return ch
+}
+
+// Stops listening for notifications on the channel.
+func (conn *PostgresConn) Unlisten(channel string) {
+	if conn.listener == nil {
+		return
+	}
+
+	nl := conn.listener
+	err := nl.listener.Unlisten(channel)
+	if err != nil && err != pq.ErrChannelNotOpen {
+		log.Panic("can't unlisten channel: ", err)
+	}
+
+	nl.mutex.Lock()
+	defer nl.mutex.Unlock()
+
+	for _, ch := range nl.subscribers[channel] {
+		close(ch)
+	}
+	delete(nl.subscribers, channel)
+}
+
+// Sends a notification to the channel.
+func (node *PostgresNode) Notify(dbname string, channel string, payload string) {
+	node.Execute(dbname, "select pg_notify($1, $2)", channel, payload)
+}
@@ -0,0 +1,41 @@
+package pqt
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestListen(t *testing.T) {
+	node := MakePostgresNode("master")
+	node.Init()
+	node.Start()
+
+	conn := MakePostgresConn(node, "postgres")
+	ch := conn.Listen("events")
+
+	sender := MakePostgresConn(node, "postgres")
+	sender.Execute("notify events, 'hello'")
+	node.Notify("postgres", "events", "world")
+
+	for _, payload := range []string{"hello", "world"} {
+		select {
+		case n := <-ch:
+			assert.Equal(t, n.Channel, "events")
+			assert.Equal(t, n.Payload, payload)
+			if payload == "hello" {
+				assert.Equal(t, n.Pid, sender.Pid())
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatal("notification has not been received")
+		}
+	}
+
+	conn.Unlisten("events")
+	_, ok := <-ch
+	assert.False(t, ok)
+
+	sender.Close()
+	conn.Close()
+	node.Stop()
+}
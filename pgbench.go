@@ -0,0 +1,373 @@
+package pqt
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	pgbenchProcessedRe = regexp.MustCompile(
+		`^number of transactions actually processed: (\d+)`)
+	pgbenchFailedRe = regexp.MustCompile(
+		`^number of failed transactions: (\d+)`)
+	pgbenchLatencyRe = regexp.MustCompile(
+		`^latency (average|stddev)\s*[=:]\s*([\d.]+) ms`)
+	pgbenchTpsRe = regexp.MustCompile(
+		`^tps = ([\d.]+)(.*)`)
+	pgbenchScriptRe = regexp.MustCompile(
+		`^SQL script (\d+):`)
+	pgbenchStatementRe = regexp.MustCompile(
+		`^\s+([\d.]+)\s+(.*)$`)
+	pgbenchStatementFailuresRe = regexp.MustCompile(
+		`^\s+([\d.]+)\s+(\d+)\s+(.*)$`)
+	pgbenchProgressRe = regexp.MustCompile(
+		`^progress: ([\d.]+) s, ([\d.]+) tps, lat ([\d.]+) ms stddev ([\d.]+|NaN)(?:, (\d+) failed)?`)
+)
+
+// Options for pgbench runs.
+type PgbenchOptions struct {
+	// Database for the benchmark, "postgres" by default.
+	Database string
+
+	Clients      int           // -c
+	Jobs         int           // -j
+	Duration     time.Duration // -T, rounded up to seconds
+	Transactions int           // -t
+	Progress     time.Duration // -P, rounded up to seconds
+	Protocol     string        // -M
+
+	// Custom script files, optionally with weights (file@weight).
+	Scripts []string
+
+	// Builtin scripts, optionally with weights (name@weight).
+	Builtin []string
+
+	// Don't vacuum tables before the run (-n).
+	NoVacuum bool
+
+	// Write per-transaction log files (-l).
+	Log bool
+
+	// Additional command line arguments.
+	Args []string
+}
+
+// Average latency of a statement in a script.
+type PgbenchStatement struct {
+	Script   int
+	Latency  time.Duration
+	Failures int
+	Command  string
+}
+
+// Progress report line (-P).
+type PgbenchProgress struct {
+	Time           time.Duration
+	TPS            float64
+	LatencyAverage time.Duration
+	LatencyStddev  time.Duration
+	Failed         int
+}
+
+// Parsed pgbench results.
+type PgbenchResult struct {
+	Transactions   int
+	Failed         int
+	TPS            float64
+	LatencyAverage time.Duration
+	LatencyStddev  time.Duration
+	Statements     []PgbenchStatement
+	Progress       []PgbenchProgress
+
+	// Per-transaction log files, if the log was requested.
+	LogFiles []string
+
+	// Raw pgbench output.
+	Output string
+}
+
+// Entry of per-transaction log.
+type PgbenchLogEntry struct {
+	Client      int
+	Transaction int
+	Latency     time.Duration
+	Script      int
+	Time        time.Time
+	Lag         time.Duration
+	Skipped     bool
+	Failed      bool
+}
+
+func parseMilliseconds(s string) time.Duration {
+	ms, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0
+	}
+	return time.Duration(ms * float64(time.Millisecond))
+}
+
+// Parses pgbench output. Progress reports are read from stderr.
+func parsePgbenchOutput(stdout, stderr string) *PgbenchResult {
+	result := &PgbenchResult{Output: stdout}
+
+	script := 1
+	inStatements := false
+	withFailures := false
+
+	scanner := bufio.NewScanner(strings.NewReader(stdout))
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		if inStatements {
+			if withFailures {
+				if match := pgbenchStatementFailuresRe.FindStringSubmatch(line); match != nil {
+					failures, _ := strconv.Atoi(match[2])
+					result.Statements = append(result.Statements, PgbenchStatement{
+						Script:   script,
+						Latency:  parseMilliseconds(match[1]),
+						Failures: failures,
+						Command:  strings.TrimSpace(match[3]),
+					})
+					continue
+				}
+			} else if match := pgbenchStatementRe.FindStringSubmatch(line); match != nil {
+				result.Statements = append(result.Statements, PgbenchStatement{
+					Script:  script,
+					Latency: parseMilliseconds(match[1]),
+					Command: strings.TrimSpace(match[2]),
+				})
+				continue
+			}
+			inStatements = false
+		}
+
+		// per-script values are prefixed by " - "
+		if strings.HasPrefix(line, " - ") {
+			if strings.Contains(line, "statement latencies in milliseconds") {
+				inStatements = true
+				withFailures = strings.Contains(line, "failures")
+			}
+			continue
+		}
+
+		if strings.HasPrefix(line, "statement latencies in milliseconds") {
+			inStatements = true
+			withFailures = strings.Contains(line, "failures")
+		} else if match := pgbenchScriptRe.FindStringSubmatch(line); match != nil {
+			script, _ = strconv.Atoi(match[1])
+		} else if match := pgbenchProcessedRe.FindStringSubmatch(line); match != nil {
+			result.Transactions, _ = strconv.Atoi(match[1])
+		} else if match := pgbenchFailedRe.FindStringSubmatch(line); match != nil {
+			result.Failed, _ = strconv.Atoi(match[1])
+		} else if match := pgbenchLatencyRe.FindStringSubmatch(line); match != nil {
+			if match[1] == "average" {
+				result.LatencyAverage = parseMilliseconds(match[2])
+			} else {
+				result.LatencyStddev = parseMilliseconds(match[2])
+			}
+		} else if match := pgbenchTpsRe.FindStringSubmatch(line); match != nil {
+			// old versions report tps including and excluding connection
+			// establishing, the latter is preferred
+			if result.TPS == 0 || !strings.Contains(match[2], "including") {
+				result.TPS, _ = strconv.ParseFloat(match[1], 64)
+			}
+		}
+	}
+
+	scanner = bufio.NewScanner(strings.NewReader(stderr))
+	for scanner.Scan() {
+		match := pgbenchProgressRe.FindStringSubmatch(scanner.Text())
+		if match == nil {
+			continue
+		}
+
+		seconds, _ := strconv.ParseFloat(match[1], 64)
+		tps, _ := strconv.ParseFloat(match[2], 64)
+		failed, _ := strconv.Atoi(match[5])
+		result.Progress = append(result.Progress, PgbenchProgress{
+			Time:           time.Duration(seconds * float64(time.Second)),
+			TPS:            tps,
+			LatencyAverage: parseMilliseconds(match[3]),
+			LatencyStddev:  parseMilliseconds(match[4]),
+			Failed:         failed,
+		})
+	}
+
+	return result
+}
+
+// Reads per-transaction log written by pgbench with -l.
+func ReadPgbenchLog(path string) ([]PgbenchLogEntry, error) {
+	var entries []PgbenchLogEntry
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var entry PgbenchLogEntry
+
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 6 {
+			return nil, fmt.Errorf("can't parse pgbench log line: %q",
+				scanner.Text())
+		}
+
+		nums := make([]int64, len(fields))
+		for i, field := range fields {
+			// latency is parsed separately, it can be "skipped"
+			// or a failure reason
+			if i == 2 {
+				continue
+			}
+			nums[i], err = strconv.ParseInt(field, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("can't parse pgbench log line: %q",
+					scanner.Text())
+			}
+		}
+
+		entry.Client = int(nums[0])
+		entry.Transaction = int(nums[1])
+		entry.Script = int(nums[3])
+		entry.Time = time.Unix(nums[4], nums[5]*int64(time.Microsecond))
+
+		if latency, err := strconv.ParseInt(fields[2], 10, 64); err == nil {
+			entry.Latency = time.Duration(latency) * time.Microsecond
+		} else if fields[2] == "skipped" {
+			entry.Skipped = true
+		} else {
+			entry.Failed = true
+		}
+
+		if len(fields) > 6 {
+			entry.Lag = time.Duration(nums[6]) * time.Microsecond
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, scanner.Err()
+}
+
+func (node *PostgresNode) runPgbench(dbname string,
+	args ...string) (string, string, error) {
+
+	var stdout, stderr bytes.Buffer
+
+	args = append(args,
+		"-h", node.host,
+		"-p", strconv.Itoa(node.Port),
+		"-U", node.user,
+		dbname)
+
+	cmd := node.Install().command("pgbench", args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	if err != nil {
+		err = fmt.Errorf("pgbench failed: %s\n%s", err, stderr.String())
+	}
+	return stdout.String(), stderr.String(), err
+}
+
+// Initializes pgbench tables with specified scale factor.
+func (node *PostgresNode) PgbenchInit(scale int, opts *PgbenchOptions) error {
+	if opts == nil {
+		opts = &PgbenchOptions{}
+	}
+
+	dbname := opts.Database
+	if dbname == "" {
+		dbname = "postgres"
+	}
+
+	args := []string{"-i", "-q", "-s", strconv.Itoa(scale)}
+	args = append(args, opts.Args...)
+
+	_, _, err := node.runPgbench(dbname, args...)
+	return err
+}
+
+// Returns the duration in seconds rounded up, pgbench doesn't accept
+// zero durations.
+func ceilSeconds(d time.Duration) string {
+	return strconv.FormatInt(int64((d+time.Second-1)/time.Second), 10)
+}
+
+// Runs pgbench against the node and returns parsed results.
+func (node *PostgresNode) Pgbench(opts *PgbenchOptions) (*PgbenchResult, error) {
+	var logDir string
+	var args []string
+
+	if opts == nil {
+		opts = &PgbenchOptions{}
+	}
+
+	dbname := opts.Database
+	if dbname == "" {
+		dbname = "postgres"
+	}
+
+	if opts.Clients > 0 {
+		args = append(args, "-c", strconv.Itoa(opts.Clients))
+	}
+	if opts.Jobs > 0 {
+		args = append(args, "-j", strconv.Itoa(opts.Jobs))
+	}
+	if opts.Duration > 0 {
+		args = append(args, "-T", ceilSeconds(opts.Duration))
+	}
+	if opts.Transactions > 0 {
+		args = append(args, "-t", strconv.Itoa(opts.Transactions))
+	}
+	if opts.Progress > 0 {
+		args = append(args, "-P", ceilSeconds(opts.Progress))
+	}
+	if opts.Protocol != "" {
+		args = append(args, "-M", opts.Protocol)
+	}
+	for _, script := range opts.Scripts {
+		args = append(args, "-f", script)
+	}
+	for _, builtin := range opts.Builtin {
+		args = append(args, "-b", builtin)
+	}
+	if opts.NoVacuum {
+		args = append(args, "-n")
+	}
+	// show per-statement latencies
+	args = append(args, "-r")
+	if opts.Log {
+		var err error
+
+		logDir, err = ioutil.TempDir(node.baseDirectory, "pgbench_")
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, "-l",
+			"--log-prefix", filepath.Join(logDir, "pgbench_log"))
+	}
+	args = append(args, opts.Args...)
+
+	stdout, stderr, err := node.runPgbench(dbname, args...)
+	result := parsePgbenchOutput(stdout, stderr)
+
+	if logDir != "" {
+		result.LogFiles, _ = filepath.Glob(filepath.Join(logDir, "pgbench_log.*"))
+		sort.Strings(result.LogFiles)
+	}
+	return result, err
+}
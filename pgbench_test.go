@@ -0,0 +1,143 @@
+package pqt
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const pgbenchOutput = `pgbench (16.1)
+transaction type: <builtin: TPC-B (sort of)>
+scaling factor: 1
+query mode: simple
+number of clients: 4
+number of threads: 2
+maximum number of tries: 1
+duration: 5 s
+number of transactions actually processed: 6173
+number of failed transactions: 2 (0.032%)
+latency average = 3.239 ms
+latency stddev = 1.518 ms
+initial connection time = 4.126 ms
+tps = 1234.567890 (without initial connection time)
+statement latencies in milliseconds and failures:
+         0.002           0  \set aid random(1, 100000 * :scale)
+         0.301           2  UPDATE pgbench_accounts SET abalance = abalance + :delta WHERE aid = :aid;
+         0.544           0  END;
+`
+
+const pgbenchOldOutput = `transaction type: multiple scripts
+scaling factor: 1
+number of transactions actually processed: 100/100
+latency average = 1.500 ms
+tps = 600.100000 (including connections establishing)
+tps = 650.200000 (excluding connections establishing)
+SQL script 1: <builtin: select only>
+ - weight: 1 (targets 50.0% of total)
+ - 48 transactions (48.0% of total, tps = 312.096000)
+ - latency average = 1.100 ms
+ - statement latencies in milliseconds:
+         0.050  \set aid random(1, 100000 * :scale)
+SQL script 2: /tmp/custom.sql
+ - weight: 1 (targets 50.0% of total)
+ - statement latencies in milliseconds:
+         1.250  select pg_sleep(0.001);
+`
+
+const pgbenchProgress = `starting vacuum...end.
+progress: 1.0 s, 1200.5 tps, lat 3.300 ms stddev 1.200, 0 failed
+progress: 2.0 s, 1250.0 tps, lat 3.100 ms stddev NaN
+`
+
+func TestParsePgbenchOutput(t *testing.T) {
+	res := parsePgbenchOutput(pgbenchOutput, pgbenchProgress)
+	assert.Equal(t, res.Transactions, 6173)
+	assert.Equal(t, res.Failed, 2)
+	assert.Equal(t, res.TPS, 1234.56789)
+	assert.Equal(t, res.LatencyAverage, 3239*time.Microsecond)
+	assert.Equal(t, res.LatencyStddev, 1518*time.Microsecond)
+	assert.Equal(t, len(res.Statements), 3)
+	assert.Equal(t, res.Statements[1].Failures, 2)
+	assert.Equal(t, res.Statements[1].Latency, 301*time.Microsecond)
+	assert.Equal(t, res.Statements[2].Command, "END;")
+	assert.Equal(t, len(res.Progress), 2)
+	assert.Equal(t, res.Progress[0].Time, time.Second)
+	assert.Equal(t, res.Progress[1].TPS, 1250.0)
+
+	res = parsePgbenchOutput(pgbenchOldOutput, "")
+	assert.Equal(t, res.Transactions, 100)
+	assert.Equal(t, res.TPS, 650.2)
+	assert.Equal(t, len(res.Statements), 2)
+	assert.Equal(t, res.Statements[0].Script, 1)
+	assert.Equal(t, res.Statements[1].Script, 2)
+	assert.Equal(t, res.Statements[1].Command, "select pg_sleep(0.001);")
+}
+
+func TestReadPgbenchLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pqt_pgbench_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "pgbench_log.123")
+	ioutil.WriteFile(path, []byte(
+		"0 1 3245 0 1700000000 123456\n"+
+			"1 1 skipped 0 1700000000 223456 15\n"+
+			"1 2 serialization 1 1700000001 0\n"), 0644)
+
+	entries, err := ReadPgbenchLog(path)
+	assert.Nil(t, err)
+	assert.Equal(t, len(entries), 3)
+	assert.Equal(t, entries[0].Latency, 3245*time.Microsecond)
+	assert.Equal(t, entries[0].Time, time.Unix(1700000000, 123456000))
+	assert.True(t, entries[1].Skipped)
+	assert.Equal(t, entries[1].Lag, 15*time.Microsecond)
+	assert.True(t, entries[2].Failed)
+	assert.Equal(t, entries[2].Script, 1)
+}
+
+func TestPgbench(t *testing.T) {
+	node := MakePostgresNode("master")
+	node.Init()
+	node.Start()
+
+	err := node.PgbenchInit(1, nil)
+	assert.Nil(t, err)
+
+	res, err := node.Pgbench(&PgbenchOptions{
+		Clients:      2,
+		Transactions: 100,
+		Builtin:      []string{"select-only"},
+		Log:          true,
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, res.Transactions, 200)
+	assert.NotEqual(t, res.TPS, 0.0)
+	assert.NotEqual(t, len(res.Statements), 0)
+	assert.NotEqual(t, len(res.LogFiles), 0)
+
+	entries, err := ReadPgbenchLog(res.LogFiles[0])
+	assert.Nil(t, err)
+	assert.NotEqual(t, len(entries), 0)
+
+	// statement latencies are reported for the default script too
+	res, err = node.Pgbench(&PgbenchOptions{
+		Duration: 500 * time.Millisecond,
+		Progress: 500 * time.Millisecond,
+	})
+	assert.Nil(t, err)
+	assert.NotEqual(t, res.Transactions, 0)
+	assert.NotEqual(t, len(res.Statements), 0)
+
+	node.Stop()
+}
+
+func TestCeilSeconds(t *testing.T) {
+	assert.Equal(t, "1", ceilSeconds(time.Millisecond))
+	assert.Equal(t, "1", ceilSeconds(time.Second))
+	assert.Equal(t, "2", ceilSeconds(1500*time.Millisecond))
+}
@@ -0,0 +1,49 @@
+package pqt
+
+import (
+	"context"
+	"fmt"
+)
+
+// Waits until a child process of postmaster with the type appears and
+// matches the predicate, which can be nil.
+func (node *PostgresNode) WaitForProcess(ctx context.Context, ptype ProcessType,
+	predicate func(*Process) bool) (*Process, error) {
+
+	var result *Process
+
+	err := poll(ctx, func() (bool, error) {
+		postmaster := node.GetProcess()
+		if postmaster == nil {
+			return false, fmt.Errorf("postmaster of %s is not running", node.name)
+		}
+
+		for _, child := range postmaster.Children() {
+			if child.Type == ptype && (predicate == nil || predicate(child)) {
+				result = child
+				return true, nil
+			}
+		}
+		return false, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("waiting for %s process: %w", ptype, err)
+	}
+	return result, nil
+}
+
+// Waits until the process exits.
+func (node *PostgresNode) WaitForProcessExit(ctx context.Context, pid int) error {
+	process := getProcessByPid(pid)
+	if process == nil {
+		return nil
+	}
+
+	err := poll(ctx, func() (bool, error) {
+		return !process.Alive(), nil
+	})
+	if err != nil {
+		return fmt.Errorf("waiting for exit of process %d: %w", pid, err)
+	}
+	return nil
+}
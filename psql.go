@@ -0,0 +1,107 @@
+package pqt
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Options for psql runs.
+type PsqlOptions struct {
+	// Stop on the first error (ON_ERROR_STOP).
+	OnErrorStop bool
+
+	// Variables set with -v.
+	Variables map[string]string
+
+	// Additional command line arguments.
+	Args []string
+}
+
+// Result of psql run.
+type PsqlResult struct {
+	Stdout   string
+	Stderr   string
+	ExitCode int
+}
+
+// Makes a psql command connected to the node. psqlrc is not read.
+func (node *PostgresNode) psqlCommand(dbname string, args ...string) *exec.Cmd {
+	args = append([]string{
+		"-X",
+		"-h", node.host,
+		"-p", strconv.Itoa(node.Port),
+		"-U", node.user,
+		"-d", dbname,
+	}, args...)
+
+	return node.Install().command("psql", args...)
+}
+
+func (node *PostgresNode) runPsql(dbname string, input io.Reader,
+	opts *PsqlOptions, args ...string) (*PsqlResult, error) {
+
+	var stdout, stderr bytes.Buffer
+
+	if opts == nil {
+		opts = &PsqlOptions{}
+	}
+
+	if opts.OnErrorStop {
+		args = append(args, "-v", "ON_ERROR_STOP=1")
+	}
+
+	names := make([]string, 0, len(opts.Variables))
+	for name := range opts.Variables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		args = append(args, "-v", name+"="+opts.Variables[name])
+	}
+	args = append(args, opts.Args...)
+
+	cmd := node.psqlCommand(dbname, args...)
+	cmd.Stdin = input
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		return nil, err
+	}
+
+	return &PsqlResult{
+		Stdout:   stdout.String(),
+		Stderr:   stderr.String(),
+		ExitCode: cmd.ProcessState.ExitCode(),
+	}, nil
+}
+
+// Runs the script with psql against the node. The script may contain
+// meta-commands. Non-zero exit code of psql is not an error, it's
+// returned in the result.
+func (node *PostgresNode) Psql(dbname string, script string,
+	opts *PsqlOptions) (*PsqlResult, error) {
+
+	return node.runPsql(dbname, strings.NewReader(script), opts)
+}
+
+// Runs the script file with psql -f against the node, so \ir includes
+// files relative to the script and errors are reported with its name.
+func (node *PostgresNode) PsqlFile(dbname string, path string,
+	opts *PsqlOptions) (*PsqlResult, error) {
+
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+
+	return node.runPsql(dbname, nil, opts, "-f", path)
+}
@@ -0,0 +1,62 @@
+package pqt
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPsql(t *testing.T) {
+	node := MakePostgresNode("master")
+	node.Init()
+	node.Start()
+
+	t.Run("output", func(t *testing.T) {
+		res, err := node.Psql("postgres", "\\pset tuples_only on\nselect :val;",
+			&PsqlOptions{Variables: map[string]string{"val": "42"}})
+		assert.Nil(t, err)
+		assert.Equal(t, res.ExitCode, 0)
+		assert.Contains(t, res.Stdout, "42")
+	})
+
+	t.Run("notice", func(t *testing.T) {
+		res, err := node.Psql("postgres",
+			"do $$ begin raise notice 'hello'; end $$;", nil)
+		assert.Nil(t, err)
+		assert.Contains(t, res.Stderr, "NOTICE:  hello")
+	})
+
+	t.Run("on error stop", func(t *testing.T) {
+		res, err := node.Psql("postgres", "select 1/0;\nselect 'after';",
+			&PsqlOptions{OnErrorStop: true})
+		assert.Nil(t, err)
+		assert.Equal(t, res.ExitCode, 3)
+		assert.Contains(t, res.Stderr, "division by zero")
+		assert.NotContains(t, res.Stdout, "after")
+	})
+
+	t.Run("file", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "pqt_psql_")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		ioutil.WriteFile(filepath.Join(dir, "main.sql"),
+			[]byte("\\ir included.sql\nselect 1/0;\n"), 0644)
+		ioutil.WriteFile(filepath.Join(dir, "included.sql"),
+			[]byte("select 'included';\n"), 0644)
+
+		res, err := node.PsqlFile("postgres", filepath.Join(dir, "main.sql"), nil)
+		assert.Nil(t, err)
+		assert.Contains(t, res.Stdout, "included")
+		assert.Contains(t, res.Stderr, "main.sql:2: ERROR:  division by zero")
+
+		_, err = node.PsqlFile("postgres", filepath.Join(dir, "missing.sql"), nil)
+		assert.NotNil(t, err)
+	})
+
+	node.Stop()
+}
@@ -1,13 +1,15 @@
 package pqt
 
 import (
-	"bytes"
 	"fmt"
 	"io/ioutil"
 	"log"
-	"os/exec"
+	"os"
+	"regexp"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
 type ProcessType byte
@@ -26,35 +28,123 @@ const (
 	WalSender                  ProcessType = iota
 	WalWriter                  ProcessType = iota
 	OtherBgWorker              ProcessType = iota
+	ClientBackend              ProcessType = iota
+	Archiver                   ProcessType = iota
+	ParallelWorker             ProcessType = iota
+	LogicalReplicationWorker   ProcessType = iota
+	WalSummarizer              ProcessType = iota
+	IoWorker                   ProcessType = iota
+	SlotSyncWorker             ProcessType = iota
+	Logger                     ProcessType = iota
 )
 
+var processTypeNames = map[ProcessType]string{
+	UnknownProcess:             "unknown",
+	Postmaster:                 "postmaster",
+	AutovacuumLauncher:         "autovacuum launcher",
+	AutovacuumWorker:           "autovacuum worker",
+	BackgroundWriter:           "background writer",
+	Checkpointer:               "checkpointer",
+	LogicalReplicationLauncher: "logical replication launcher",
+	Startup:                    "startup",
+	StatsCollector:             "stats collector",
+	WalReceiver:                "walreceiver",
+	WalSender:                  "walsender",
+	WalWriter:                  "walwriter",
+	OtherBgWorker:              "background worker",
+	ClientBackend:              "client backend",
+	Archiver:                   "archiver",
+	ParallelWorker:             "parallel worker",
+	LogicalReplicationWorker:   "logical replication worker",
+	WalSummarizer:              "walsummarizer",
+	IoWorker:                   "io worker",
+	SlotSyncWorker:             "slotsync worker",
+	Logger:                     "logger",
+}
+
+func (ptype ProcessType) String() string {
+	if name, ok := processTypeNames[ptype]; ok {
+		return name
+	}
+	return fmt.Sprintf("ProcessType(%d)", ptype)
+}
+
+// Prefixes of process titles, checked in order. Titles of postgres
+// before 11 have " process" suffix, like "writer process".
+var processTitlePrefixes = []struct {
+	prefix string
+	ptype  ProcessType
+}{
+	{"autovacuum launcher", AutovacuumLauncher},
+	{"autovacuum worker", AutovacuumWorker},
+	{"background writer", BackgroundWriter},
+	{"writer process", BackgroundWriter},
+	{"checkpointer", Checkpointer},
+	{"startup", Startup},
+	{"stats collector", StatsCollector},
+	{"walreceiver", WalReceiver},
+	{"wal receiver", WalReceiver},
+	{"walsender", WalSender},
+	{"wal sender", WalSender},
+	{"walwriter", WalWriter},
+	{"wal writer", WalWriter},
+	{"walsummarizer", WalSummarizer},
+	{"archiver", Archiver},
+	{"logger", Logger},
+	{"io worker", IoWorker},
+	{"slotsync worker", SlotSyncWorker},
+	{"logical replication launcher", LogicalReplicationLauncher},
+	{"logical replication", LogicalReplicationWorker},
+	{"parallel worker", ParallelWorker},
+}
+
+// Clock ticks per second used in /proc/<pid>/stat, it's 100 on all
+// supported Linux platforms.
+const clockTicks = 100
+
 type Process struct {
 	Type      ProcessType
 	CmdLine   string
 	Pid       int
 	ParentPid int
+
+	// Parsed from the process title of client backends and walsenders.
+	ClusterName   string
+	User          string
+	Database      string
+	ClientAddress string
+	State         string
+
+	// Read from /proc/<pid>/stat and /proc/<pid>/status.
+	ProcState  string
+	StartTime  time.Time
+	RSS        int64
+	PeakRSS    int64
+	VSZ        int64
+	UserTime   time.Duration
+	SystemTime time.Duration
+	Threads    int
 }
 
+// Returns child processes. Processes exited during the listing are
+// skipped.
 func (process *Process) Children() (result []*Process) {
-	var out bytes.Buffer
-	cmd := exec.Command("pgrep", "-P", strconv.Itoa(process.Pid))
-	cmd.Stdout = &out
-	err := cmd.Run()
-
+	entries, err := ioutil.ReadDir("/proc")
 	if err != nil {
-		log.Panic("pgrep launch error: ", err)
+		log.Panic("can't read /proc: ", err)
 	}
 
-	pids := strings.Split(out.String(), "\n")
-	for _, spid := range pids {
-		if spid == "" {
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil || !entry.IsDir() {
 			continue
 		}
 
-		pid, err := strconv.Atoi(spid)
-		if err != nil {
-			log.Panicf("can't convert pgrep line ('%s') to int", spid)
+		stat, err := readProcStat(pid)
+		if err != nil || stat.ppid != process.Pid {
+			continue
 		}
+
 		child := getProcessByPid(pid)
 		if child != nil {
 			result = append(result, child)
@@ -63,72 +153,256 @@ func (process *Process) Children() (result []*Process) {
 	return result
 }
 
-func getProcessType(process *Process) (result ProcessType) {
-	ProcessTypeBasicIdent := map[ProcessType][]string{
-		AutovacuumLauncher: []string{"autovacuumlauncher"},
-		AutovacuumWorker:   []string{"autovacuumworker"},
-		BackgroundWriter:   []string{"backgroundwriter", "writer"},
-		Checkpointer:       []string{"checkpointer"},
-		Startup:            []string{"startup"},
-		StatsCollector:     []string{"statscollector"},
-		WalReceiver:        []string{"walreceiver"},
-		WalSender:          []string{"walsender"},
-		WalWriter:          []string{"walwriter"},
-		LogicalReplicationLauncher: []string{"logicalreplicationlauncher",
-			"logicalreplicationworker"},
-	}
-
-	result = UnknownProcess
-
-	cmdline := process.CmdLine
-	cmdline = strings.Replace(cmdline, " ", "", -1)
-	cmdline = strings.Replace(cmdline, "postgres:", "", 1)
-
-	tmp := cmdline
-	cmdline = strings.Replace(cmdline, "bgworker:", "", 1)
-	is_bgworker := tmp != cmdline
-
-	for ptype, items := range ProcessTypeBasicIdent {
-		for _, item := range items {
-			if strings.HasPrefix(cmdline, item) {
-				result = ptype
-				goto end
+// Rereads process statistics. Returns an error if the process has exited.
+func (process *Process) Refresh() error {
+	stat, err := readProcStat(process.Pid)
+	if err != nil {
+		return err
+	}
+
+	process.ParentPid = stat.ppid
+	process.ProcState = stat.state
+	process.StartTime = stat.startTime
+	process.VSZ = stat.vsz
+	process.RSS = stat.rss
+	process.UserTime = stat.utime
+	process.SystemTime = stat.stime
+	process.Threads = stat.threads
+
+	if peak, err := readProcStatusValue(process.Pid, "VmHWM"); err == nil {
+		process.PeakRSS = peak
+	}
+	return nil
+}
+
+// Returns true if the process still exists, is not a zombie and its pid
+// was not reused.
+func (process *Process) Alive() bool {
+	stat, err := readProcStat(process.Pid)
+	return err == nil && stat.state != "Z" && stat.startTime.Equal(process.StartTime)
+}
+
+// Returns the number of open file descriptors.
+func (process *Process) OpenFiles() (int, error) {
+	fds, err := ioutil.ReadDir(fmt.Sprintf("/proc/%d/fd", process.Pid))
+	if err != nil {
+		return 0, err
+	}
+	return len(fds), nil
+}
+
+// Returns environment variables of the process.
+func (process *Process) Environ() (map[string]string, error) {
+	data, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/environ", process.Pid))
+	if err != nil {
+		return nil, err
+	}
+
+	env := make(map[string]string)
+	for _, item := range strings.Split(string(data), "\x00") {
+		if parts := strings.SplitN(item, "=", 2); len(parts) == 2 {
+			env[parts[0]] = parts[1]
+		}
+	}
+	return env, nil
+}
+
+// Fields of /proc/<pid>/stat.
+type procStat struct {
+	state     string
+	ppid      int
+	utime     time.Duration
+	stime     time.Duration
+	threads   int
+	startTime time.Time
+	vsz       int64
+	rss       int64
+}
+
+var (
+	bootTimeOnce sync.Once
+	bootTime     time.Time
+)
+
+func getBootTime() time.Time {
+	bootTimeOnce.Do(func() {
+		data, err := ioutil.ReadFile("/proc/stat")
+		if err != nil {
+			return
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			if fields := strings.Fields(line); len(fields) == 2 && fields[0] == "btime" {
+				sec, _ := strconv.ParseInt(fields[1], 10, 64)
+				bootTime = time.Unix(sec, 0)
 			}
 		}
+	})
+	return bootTime
+}
+
+func ticksToDuration(ticks int64) time.Duration {
+	return time.Duration(ticks) * time.Second / clockTicks
+}
+
+// Parses /proc/<pid>/stat line. The command name can contain spaces and
+// parentheses, so the fields are read after the last ')'.
+func parseProcStat(line string) (*procStat, error) {
+	pos := strings.LastIndex(line, ")")
+	if pos < 0 {
+		return nil, fmt.Errorf("can't parse stat line: %q", line)
 	}
 
-	if result == UnknownProcess && is_bgworker {
-		result = OtherBgWorker
+	fields := strings.Fields(line[pos+1:])
+	if len(fields) < 22 {
+		return nil, fmt.Errorf("stat line has %d fields", len(fields))
 	}
 
-end:
-	return result
+	num := func(i int) int64 {
+		n, _ := strconv.ParseInt(fields[i], 10, 64)
+		return n
+	}
+
+	return &procStat{
+		state:     fields[0],
+		ppid:      int(num(1)),
+		utime:     ticksToDuration(num(11)),
+		stime:     ticksToDuration(num(12)),
+		threads:   int(num(17)),
+		startTime: getBootTime().Add(ticksToDuration(num(19))),
+		vsz:       num(20),
+		rss:       num(21) * int64(os.Getpagesize()),
+	}, nil
 }
 
-func getProcessByPid(pid int) (result *Process) {
-	if pid <= 0 {
-		return nil
+func readProcStat(pid int) (*procStat, error) {
+	data, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return nil, err
 	}
+	return parseProcStat(string(data))
+}
 
-	procDir := fmt.Sprintf("/proc/%d/", pid)
-	cmdline, err := ioutil.ReadFile(procDir + "cmdline")
+// Returns a value in bytes from /proc/<pid>/status, like "VmHWM: 10 kB".
+func readProcStatusValue(pid int, key string) (int64, error) {
+	data, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/status", pid))
 	if err != nil {
-		log.Panicf("can't read /proc/%d/cmdline", pid)
+		return 0, err
 	}
 
-	statline, err := ioutil.ReadFile(procDir + "stat")
-	if err != nil {
-		log.Panicf("can't read /proc/%d/stat", pid)
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || fields[0] != key+":" {
+			continue
+		}
+
+		value, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return 0, err
+		}
+		if len(fields) > 2 && fields[2] == "kB" {
+			value *= 1024
+		}
+		return value, nil
+	}
+	return 0, fmt.Errorf("%s not found in /proc/%d/status", key, pid)
+}
+
+var (
+	clusterNamePrefix = regexp.MustCompile(`^([^\s:]+): (.*)$`)
+	clientAddress     = regexp.MustCompile(`^(\[local\]|.*\(\d+\))$`)
+)
+
+// Parses "user [database] address state" part of the title.
+func parseConnectionTitle(process *Process, title string) bool {
+	fields := strings.Fields(title)
+	for i, field := range fields {
+		if i == 0 || i > 2 || !clientAddress.MatchString(field) {
+			continue
+		}
+
+		process.User = fields[0]
+		if i == 2 {
+			process.Database = fields[1]
+		}
+		process.ClientAddress = field
+		process.State = strings.Join(fields[i+1:], " ")
+		return true
+	}
+	return false
+}
+
+// Recognizes the process by its title, like "postgres: checkpointer",
+// and fills the fields parsed from the title.
+func getProcessType(process *Process) ProcessType {
+	title := strings.Join(strings.Fields(
+		strings.Replace(process.CmdLine, "\x00", " ", -1)), " ")
+	if !strings.HasPrefix(title, "postgres: ") {
+		return UnknownProcess
+	}
+	title = strings.TrimPrefix(title, "postgres: ")
+
+	// the title is prefixed by cluster_name if it's set
+	if m := clusterNamePrefix.FindStringSubmatch(title); m != nil &&
+		m[1] != "bgworker" {
+
+		process.ClusterName = m[1]
+		title = m[2]
 	}
-	ppid, err := strconv.Atoi(strings.Split(string(statline), " ")[3])
+
+	isBgworker := strings.HasPrefix(title, "bgworker: ")
+	title = strings.TrimPrefix(title, "bgworker: ")
+
+	for _, item := range processTitlePrefixes {
+		if !hasTitlePrefix(title, item.prefix) {
+			continue
+		}
+
+		if item.ptype == WalSender {
+			rest := strings.TrimPrefix(title, item.prefix)
+			parseConnectionTitle(process, strings.TrimPrefix(rest, " process"))
+			return item.ptype
+		}
+
+		// a client backend of a user named like a process
+		if !isBgworker && parseConnectionTitle(process, title) {
+			return ClientBackend
+		}
+		return item.ptype
+	}
+
+	if isBgworker {
+		return OtherBgWorker
+	}
+	if parseConnectionTitle(process, title) {
+		return ClientBackend
+	}
+	return UnknownProcess
+}
+
+// Checks that the title starts with the prefix followed by a space
+// or the end of the title.
+func hasTitlePrefix(title string, prefix string) bool {
+	return strings.HasPrefix(title, prefix) &&
+		(len(title) == len(prefix) || title[len(prefix)] == ' ')
+}
+
+// Returns the process or nil if it doesn't exist.
+func getProcessByPid(pid int) (result *Process) {
+	if pid <= 0 {
+		return nil
+	}
+
+	cmdline, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/cmdline", pid))
 	if err != nil {
-		log.Panicf("can't read parent pid")
+		return nil
 	}
 
 	result = &Process{
-		Pid:       pid,
-		CmdLine:   string(cmdline),
-		ParentPid: ppid,
+		Pid:     pid,
+		CmdLine: string(cmdline),
+	}
+	if result.Refresh() != nil {
+		return nil
 	}
 	result.Type = getProcessType(result)
 	return result
@@ -1,8 +1,13 @@
 package pqt
 
 import (
+	"context"
 	"github.com/stretchr/testify/assert"
+	"os"
+	"os/exec"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestPsUtils(t *testing.T) {
@@ -21,8 +26,21 @@ func TestPsUtils(t *testing.T) {
 		assert.Equal(t, process.Pid, node.Pid())
 		assert.Equal(t, process.Type, Postmaster)
 
-		children := process.Children()
-		assert.NotEqual(t, len(children), 0)
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		// new processes are unknown until they set their titles
+		var children []*Process
+		err := poll(ctx, func() (bool, error) {
+			children = process.Children()
+			for _, child := range children {
+				if child.Type == UnknownProcess {
+					return false, nil
+				}
+			}
+			return len(children) > 0, nil
+		})
+		assert.Nil(t, err)
 
 		for _, child := range children {
 			assert.NotEqual(t, child.Pid, 0)
@@ -32,5 +50,174 @@ func TestPsUtils(t *testing.T) {
 		}
 	})
 
+	t.Run("wait", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		checkpointer, err := node.WaitForProcess(ctx, Checkpointer, nil)
+		assert.Nil(t, err)
+		assert.Equal(t, node.Pid(), checkpointer.ParentPid)
+
+		conn := MakePostgresConn(node, "postgres")
+		pid := conn.Pid()
+		backend, err := node.WaitForProcess(ctx, ClientBackend,
+			func(p *Process) bool { return p.Pid == pid })
+		assert.Nil(t, err)
+		assert.Equal(t, pid, backend.Pid)
+
+		conn.Close()
+		assert.Nil(t, node.WaitForProcessExit(ctx, pid))
+
+		shortCtx, shortCancel := context.WithTimeout(ctx, 100*time.Millisecond)
+		defer shortCancel()
+		_, err = node.WaitForProcess(shortCtx, ClientBackend,
+			func(p *Process) bool { return p.Pid == pid })
+		assert.NotNil(t, err)
+	})
+
 	node.Stop()
 }
+
+func TestWaitForProcessExit(t *testing.T) {
+	node := MakePostgresNode("master")
+
+	cmd := exec.Command("sleep", "10")
+	assert.Nil(t, cmd.Start())
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		cmd.Process.Kill()
+		cmd.Wait()
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	assert.Nil(t, node.WaitForProcessExit(ctx, cmd.Process.Pid))
+}
+
+func TestGetProcessType(t *testing.T) {
+	cases := []struct {
+		cmdline string
+		ptype   ProcessType
+	}{
+		{"/usr/bin/postgres\x00-D\x00/tmp/data\x00", UnknownProcess},
+		{"postgres: checkpointer   ", Checkpointer},
+		{"postgres: checkpointer process   ", Checkpointer},
+		{"postgres: background writer   ", BackgroundWriter},
+		{"postgres: writer process   ", BackgroundWriter},
+		{"postgres: walwriter   ", WalWriter},
+		{"postgres: wal writer process   ", WalWriter},
+		{"postgres: autovacuum launcher   ", AutovacuumLauncher},
+		{"postgres: autovacuum worker postgres", AutovacuumWorker},
+		{"postgres: stats collector   ", StatsCollector},
+		{"postgres: archiver last was 000000010000000000000001", Archiver},
+		{"postgres: logger   ", Logger},
+		{"postgres: startup recovering 000000010000000000000003", Startup},
+		{"postgres: walreceiver streaming 0/3000148", WalReceiver},
+		{"postgres: walsummarizer   ", WalSummarizer},
+		{"postgres: io worker 0", IoWorker},
+		{"postgres: slotsync worker", SlotSyncWorker},
+		{"postgres: logical replication launcher   ", LogicalReplicationLauncher},
+		{"postgres: bgworker: logical replication launcher   ", LogicalReplicationLauncher},
+		{"postgres: logical replication apply worker for subscription 16394", LogicalReplicationWorker},
+		{"postgres: logical replication worker for subscription 16394", LogicalReplicationWorker},
+		{"postgres: parallel worker for PID 1234", ParallelWorker},
+		{"postgres: bgworker: pg_pathman", OtherBgWorker},
+		{"postgres: pg_cron launcher", UnknownProcess},
+		{"postgres: ildus postgres [local] idle", ClientBackend},
+		{"postgres: startup_app postgres [local] idle", ClientBackend},
+		{"postgres: startup postgres [local] idle", ClientBackend},
+		{"postgres: archiver_user postgres 127.0.0.1(5000) idle", ClientBackend},
+	}
+
+	for _, c := range cases {
+		process := &Process{CmdLine: c.cmdline}
+		assert.Equal(t, c.ptype, getProcessType(process), c.cmdline)
+	}
+}
+
+func TestProcessTitleFields(t *testing.T) {
+	process := &Process{
+		CmdLine: "postgres: main: ildus regression 127.0.0.1(54321) idle in transaction",
+	}
+	assert.Equal(t, ClientBackend, getProcessType(process))
+	assert.Equal(t, "main", process.ClusterName)
+	assert.Equal(t, "ildus", process.User)
+	assert.Equal(t, "regression", process.Database)
+	assert.Equal(t, "127.0.0.1(54321)", process.ClientAddress)
+	assert.Equal(t, "idle in transaction", process.State)
+
+	process = &Process{CmdLine: "postgres: walsender repl 127.0.0.1(40000) streaming 0/3000060"}
+	assert.Equal(t, WalSender, getProcessType(process))
+	assert.Equal(t, "repl", process.User)
+	assert.Equal(t, "", process.Database)
+	assert.Equal(t, "streaming 0/3000060", process.State)
+
+	process = &Process{CmdLine: "postgres: main: checkpointer"}
+	assert.Equal(t, Checkpointer, getProcessType(process))
+	assert.Equal(t, "main", process.ClusterName)
+	assert.Equal(t, "checkpointer", Checkpointer.String())
+}
+
+func TestParseProcStat(t *testing.T) {
+	stat, err := parseProcStat("1234 (postgres: a) b (c)) S 1200 1234 1234 0 -1 " +
+		"4194560 500 0 0 0 150 50 0 0 20 0 3 0 1000 170000000 2000 " +
+		"18446744073709551615 1 1 0 0 0 0 0 0 0 0 0 0 17 0 0 0 0 0 0")
+	assert.Nil(t, err)
+	assert.Equal(t, "S", stat.state)
+	assert.Equal(t, 1200, stat.ppid)
+	assert.Equal(t, 1500*time.Millisecond, stat.utime)
+	assert.Equal(t, 500*time.Millisecond, stat.stime)
+	assert.Equal(t, 3, stat.threads)
+	assert.Equal(t, int64(170000000), stat.vsz)
+	assert.Equal(t, int64(2000*os.Getpagesize()), stat.rss)
+
+	_, err = parseProcStat("1234 (short) S 1")
+	assert.NotNil(t, err)
+}
+
+func TestProcessInfo(t *testing.T) {
+	process := getProcessByPid(os.Getpid())
+	assert.NotNil(t, process)
+	assert.Equal(t, os.Getppid(), process.ParentPid)
+	assert.Greater(t, process.RSS, int64(0))
+	assert.Greater(t, process.PeakRSS, int64(0))
+	assert.Greater(t, process.VSZ, int64(0))
+	assert.Greater(t, process.Threads, 0)
+	assert.True(t, process.StartTime.Before(time.Now()))
+	assert.True(t, process.Alive())
+
+	fds, err := process.OpenFiles()
+	assert.Nil(t, err)
+	assert.Greater(t, fds, 0)
+
+	// /proc/<pid>/environ has the environment the process was started with
+	cmd := exec.Command("sleep", "10")
+	cmd.Env = append(os.Environ(), "PQT_TEST_ENV=value")
+	if !assert.Nil(t, cmd.Start()) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// the environment is replaced when the child execs sleep
+	var env map[string]string
+	err = poll(ctx, func() (bool, error) {
+		child := getProcessByPid(cmd.Process.Pid)
+		if child == nil || !strings.HasPrefix(child.CmdLine, "sleep") {
+			return false, nil
+		}
+
+		var err error
+		env, err = child.Environ()
+		return true, err
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "value", env["PQT_TEST_ENV"])
+
+	cmd.Process.Kill()
+	cmd.Wait()
+
+	// exited processes are not found
+	assert.Nil(t, getProcessByPid(cmd.Process.Pid))
+}
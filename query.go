@@ -0,0 +1,172 @@
+package pqt
+
+import (
+	"database/sql"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Runs queries returning errors instead of panics.
+// Implemented by PostgresConn and Tx.
+type Queryer interface {
+	Query(sql string, params ...interface{}) (*sql.Rows, error)
+}
+
+// Query result with all values converted to text. NULLs are empty
+// strings in Rows and are marked in Nulls.
+type Table struct {
+	Columns []string
+	Rows    [][]string
+	Nulls   [][]bool
+}
+
+// Returns true if the value is NULL.
+func (table *Table) IsNull(row, col int) bool {
+	return table.Nulls[row][col]
+}
+
+// Returns the values as pointers, NULLs are nil.
+func (table *Table) cells() [][]*string {
+	cells := make([][]*string, len(table.Rows))
+	for i, row := range table.Rows {
+		cells[i] = make([]*string, len(row))
+		for j := range row {
+			if !table.Nulls[i][j] {
+				cells[i][j] = &row[j]
+			}
+		}
+	}
+	return cells
+}
+
+// Executes the query and returns the first column of the first row.
+// Returns sql.ErrNoRows if the query returned nothing.
+func QueryScalar[T any](q Queryer, query string, params ...interface{}) (T, error) {
+	var value T
+
+	rows, err := q.Query(query, params...)
+	if err != nil {
+		return value, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return value, err
+		}
+		return value, sql.ErrNoRows
+	}
+
+	if err := rows.Scan(&value); err != nil {
+		return value, err
+	}
+	return value, rows.Close()
+}
+
+// Returns struct fields to scan the columns into. Columns are mapped to
+// fields by `db` tag, or by lowercased field names.
+func structFields(value reflect.Value, columns []string) ([]interface{}, error) {
+	fields := make(map[string]int)
+
+	typ := value.Type()
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		if field.PkgPath != "" {
+			continue // unexported
+		}
+
+		name := field.Tag.Get("db")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(field.Name)
+		}
+		fields[name] = i
+	}
+
+	dest := make([]interface{}, len(columns))
+	for i, column := range columns {
+		idx, ok := fields[column]
+		if !ok {
+			return nil, fmt.Errorf("no field for column %q in %s", column, typ)
+		}
+		dest[i] = value.Field(idx).Addr().Interface()
+	}
+	return dest, nil
+}
+
+// Executes the query and maps resulting rows to structs.
+func QueryStructs[T any](q Queryer, query string, params ...interface{}) ([]T, error) {
+	var result []T
+
+	rows, err := q.Query(query, params...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	for rows.Next() {
+		var item T
+
+		value := reflect.ValueOf(&item).Elem()
+		if value.Kind() != reflect.Struct {
+			return nil, fmt.Errorf("%s is not a struct", value.Type())
+		}
+
+		dest, err := structFields(value, columns)
+		if err != nil {
+			return nil, err
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		result = append(result, item)
+	}
+
+	return result, rows.Err()
+}
+
+// Executes the query and returns the result as text table.
+func QueryTable(q Queryer, query string, params ...interface{}) (*Table, error) {
+	rows, err := q.Query(query, params...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	table := &Table{Columns: columns}
+	for rows.Next() {
+		values := make([]sql.NullString, len(columns))
+		dest := make([]interface{}, len(columns))
+		for i := range values {
+			dest[i] = &values[i]
+		}
+
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+
+		row := make([]string, len(columns))
+		nulls := make([]bool, len(columns))
+		for i := range values {
+			row[i] = values[i].String
+			nulls[i] = !values[i].Valid
+		}
+		table.Rows = append(table.Rows, row)
+		table.Nulls = append(table.Nulls, nulls)
+	}
+
+	return table, rows.Err()
+}
@@ -0,0 +1,70 @@
+package pqt
+
+import (
+	"database/sql"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestQueryHelpers(t *testing.T) {
+	type item struct {
+		Id    int
+		Title string         `db:"name"`
+		Note  sql.NullString `db:"note"`
+	}
+
+	node := MakePostgresNode("master")
+	node.Init()
+	node.Start()
+	node.Execute("postgres", "create table items(id int, name text, note text)")
+	node.Execute("postgres", "insert into items values (1, 'one', null), (2, 'two', 'x')")
+
+	conn := MakePostgresConn(node, "postgres")
+
+	t.Run("scalar", func(t *testing.T) {
+		count, err := QueryScalar[int](conn, "select count(*) from items")
+		assert.Nil(t, err)
+		assert.Equal(t, count, 2)
+
+		_, err = QueryScalar[int](conn, "select id from items where id = 3")
+		assert.Equal(t, err, sql.ErrNoRows)
+
+		_, err = QueryScalar[int](conn, "select from nowhere")
+		assert.NotNil(t, err)
+	})
+
+	t.Run("row", func(t *testing.T) {
+		var id int
+		var name string
+
+		err := node.QueryRow("postgres", "select id, name from items where id = $1", 2).
+			Scan(&id, &name)
+		assert.Nil(t, err)
+		assert.Equal(t, id, 2)
+		assert.Equal(t, name, "two")
+	})
+
+	t.Run("structs", func(t *testing.T) {
+		items, err := QueryStructs[item](conn, "select * from items order by id")
+		assert.Nil(t, err)
+		assert.Equal(t, len(items), 2)
+		assert.Equal(t, items[0].Title, "one")
+		assert.False(t, items[0].Note.Valid)
+		assert.Equal(t, items[1].Note.String, "x")
+
+		_, err = QueryStructs[item](conn, "select 1 as unknown")
+		assert.NotNil(t, err)
+	})
+
+	t.Run("table", func(t *testing.T) {
+		table, err := node.QueryTable("postgres", "select * from items order by id")
+		assert.Nil(t, err)
+		assert.Equal(t, table.Columns, []string{"id", "name", "note"})
+		assert.Equal(t, table.Rows, [][]string{{"1", "one", ""}, {"2", "two", "x"}})
+		assert.True(t, table.IsNull(0, 2))
+		assert.False(t, table.IsNull(1, 2))
+	})
+
+	conn.Close()
+	node.Stop()
+}
@@ -0,0 +1,188 @@
+package pqt
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/lib/pq"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// Options for regression tests run.
+type RegressOptions struct {
+	// Database for tests, recreated before the run.
+	// "contrib_regression" by default.
+	Database string
+
+	// Tests to run in specified order. By default all sql/*.sql files
+	// are run in alphabetical order.
+	Tests []string
+
+	// Directory for results/*.out, the tests directory by default.
+	OutputDir string
+}
+
+// Returns names of all tests in sql directory.
+func listRegressTests(dir string) ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "sql", "*.sql"))
+	if err != nil {
+		return nil, err
+	}
+
+	tests := make([]string, 0, len(files))
+	for _, file := range files {
+		tests = append(tests, strings.TrimSuffix(filepath.Base(file), ".sql"))
+	}
+	sort.Strings(tests)
+	return tests, nil
+}
+
+// Recreates the database for tests the same way pg_regress does.
+func (node *PostgresNode) createRegressDatabase(dbname string) {
+	ident := pq.QuoteIdentifier(dbname)
+	node.Execute("postgres", "drop database if exists "+ident)
+	node.Execute("postgres", "create database "+ident+" template = template0")
+
+	for _, setting := range []string{
+		"lc_messages to 'C'",
+		"lc_monetary to 'C'",
+		"lc_numeric to 'C'",
+		"lc_time to 'C'",
+		"bytea_output to 'hex'",
+		"timezone_abbreviations to 'Default'",
+	} {
+		node.Execute("postgres",
+			fmt.Sprintf("alter database %s set %s", ident, setting))
+	}
+}
+
+// Runs the test script with psql and returns its output. Errors in the
+// script are a part of the output, so an error is returned only if psql
+// can't be run or exits abnormally, like on a lost connection.
+func (node *PostgresNode) runRegressTest(dbname, name,
+	script string) (string, error) {
+
+	var out bytes.Buffer
+
+	f, err := os.Open(script)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	cmd := node.psqlCommand(dbname, "-a", "-q",
+		"-v", "HIDE_TABLEAM=on",
+		"-v", "HIDE_TOAST_COMPRESSION=on")
+	cmd.Env = append(os.Environ(),
+		"PGTZ=PST8PDT",
+		"PGDATESTYLE=Postgres, MDY",
+		"PGAPPNAME=pg_regress/"+name,
+		"LC_MESSAGES=C")
+	cmd.Stdin = f
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+
+	if err := cmd.Run(); err != nil {
+		return out.String(), fmt.Errorf("psql failed: %w", err)
+	}
+	return out.String(), nil
+}
+
+// Compares the result with expected/<name>.out and its alternatives
+// (<name>_1.out ... <name>_9.out). Returns empty string if any of them
+// matches, otherwise the smallest diff.
+func compareRegressResult(dir, name, resultFile, result string) string {
+	var best string
+
+	candidates := []string{filepath.Join(dir, "expected", name+".out")}
+	for i := 1; i <= 9; i++ {
+		candidates = append(candidates,
+			filepath.Join(dir, "expected", fmt.Sprintf("%s_%d.out", name, i)))
+	}
+
+	for _, expectedFile := range candidates {
+		expected, err := ioutil.ReadFile(expectedFile)
+		if err != nil {
+			continue
+		}
+
+		diff := unifiedDiff(expectedFile, resultFile, string(expected), result)
+		if diff == "" {
+			return ""
+		}
+		if best == "" || len(diff) < len(best) {
+			best = diff
+		}
+	}
+
+	if best == "" {
+		best = fmt.Sprintf("expected output for %s is not found", name)
+	}
+	return best
+}
+
+// Runs pg_regress style test suite from the directory against the node.
+// Each sql/<name>.sql script is executed by psql, its output is written
+// to results/<name>.out and compared with expected/<name>.out. Every test
+// is run as a subtest which fails with unified diff of the outputs.
+// Returns true if all tests have passed.
+func RunRegress(t *testing.T, node *PostgresNode, dir string,
+	opts *RegressOptions) bool {
+
+	if opts == nil {
+		opts = &RegressOptions{}
+	}
+
+	dbname := opts.Database
+	if dbname == "" {
+		dbname = "contrib_regression"
+	}
+
+	outputDir := opts.OutputDir
+	if outputDir == "" {
+		outputDir = dir
+	}
+	resultsDir := filepath.Join(outputDir, "results")
+	if err := os.MkdirAll(resultsDir, os.ModePerm); err != nil {
+		t.Fatal("can't create results directory: ", err)
+	}
+
+	tests := opts.Tests
+	if len(tests) == 0 {
+		var err error
+		tests, err = listRegressTests(dir)
+		if err != nil {
+			t.Fatal("can't list regression tests: ", err)
+		}
+	}
+
+	node.createRegressDatabase(dbname)
+
+	passed := true
+	for _, name := range tests {
+		name := name
+		ok := t.Run(name, func(t *testing.T) {
+			script := filepath.Join(dir, "sql", name+".sql")
+			resultFile := filepath.Join(resultsDir, name+".out")
+
+			result, runErr := node.runRegressTest(dbname, name, script)
+			err := ioutil.WriteFile(resultFile, []byte(result), 0644)
+			if err != nil {
+				t.Fatal("can't write result: ", err)
+			}
+			if runErr != nil {
+				t.Error(runErr)
+			}
+
+			if diff := compareRegressResult(dir, name, resultFile, result); diff != "" {
+				t.Error(diff)
+			}
+		})
+		passed = passed && ok
+	}
+	return passed
+}
@@ -0,0 +1,73 @@
+package pqt
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	assert.Equal(t, unifiedDiff("a", "b", "one\ntwo\n", "one\ntwo\n"), "")
+
+	from := "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n"
+	to := "1\n2\n3\n4\nfive\n6\n7\n8\n9\n10\n11\n"
+	expected := `--- expected
++++ result
+@@ -2,9 +2,10 @@
+ 2
+ 3
+ 4
+-5
++five
+ 6
+ 7
+ 8
+ 9
+ 10
++11
+`
+	assert.Equal(t, expected, unifiedDiff("expected", "result", from, to))
+
+	expected = `--- expected
++++ result
+@@ -0,0 +1,1 @@
++one
+`
+	assert.Equal(t, expected, unifiedDiff("expected", "result", "", "one\n"))
+}
+
+func TestRunRegress(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pqt_regress_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	os.Mkdir(filepath.Join(dir, "sql"), os.ModePerm)
+	os.Mkdir(filepath.Join(dir, "expected"), os.ModePerm)
+	ioutil.WriteFile(filepath.Join(dir, "sql", "one.sql"),
+		[]byte("select 1 as a;\n"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "expected", "one.out"),
+		[]byte("select 1 as a;\n a \n---\n 1\n(1 row)\n\n"), 0644)
+
+	node := MakePostgresNode("master")
+	node.Init()
+	node.Start()
+
+	assert.True(t, RunRegress(t, node, dir, nil))
+	_, err = os.Stat(filepath.Join(dir, "results", "one.out"))
+	assert.Nil(t, err)
+
+	// names are quoted as SQL identifiers
+	assert.True(t, RunRegress(t, node, dir, &RegressOptions{
+		Database: `pqt\"regress ё`,
+	}))
+
+	_, err = node.runRegressTest("postgres", "missing",
+		filepath.Join(dir, "sql", "missing.sql"))
+	assert.NotNil(t, err)
+
+	node.Stop()
+}
@@ -0,0 +1,150 @@
+package pqt
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Number of last log lines reported when a message is not found.
+const logTailLines = 20
+
+// Position in the server log file.
+type LogMark int64
+
+// Server log line with its offset in the file.
+type logLine struct {
+	offset int64
+	text   string
+}
+
+// Lines read from the server log since the node was created.
+type serverLog struct {
+	mutex   sync.Mutex
+	lines   []logLine
+	updated chan struct{}
+}
+
+func (sl *serverLog) append(offset int64, text string) {
+	sl.mutex.Lock()
+	defer sl.mutex.Unlock()
+
+	sl.lines = append(sl.lines, logLine{offset: offset, text: text})
+	if sl.updated != nil {
+		close(sl.updated)
+		sl.updated = nil
+	}
+}
+
+func (sl *serverLog) reset() {
+	sl.mutex.Lock()
+	defer sl.mutex.Unlock()
+
+	sl.lines = nil
+}
+
+// Returns index of the first line written at or after the offset.
+func (sl *serverLog) index(offset int64) int {
+	sl.mutex.Lock()
+	defer sl.mutex.Unlock()
+
+	return sort.Search(len(sl.lines), func(i int) bool {
+		return sl.lines[i].offset >= offset
+	})
+}
+
+// Returns lines starting from the index and a channel which is closed
+// when new lines are added.
+func (sl *serverLog) since(pos int) ([]string, <-chan struct{}) {
+	sl.mutex.Lock()
+	defer sl.mutex.Unlock()
+
+	if sl.updated == nil {
+		sl.updated = make(chan struct{})
+	}
+	if pos > len(sl.lines) {
+		pos = len(sl.lines)
+	}
+
+	lines := make([]string, 0, len(sl.lines)-pos)
+	for _, line := range sl.lines[pos:] {
+		lines = append(lines, line.text)
+	}
+	return lines, sl.updated
+}
+
+func (sl *serverLog) tail(n int) []string {
+	lines, _ := sl.since(0)
+	if n > len(lines) {
+		n = len(lines)
+	}
+	return lines[len(lines)-n:]
+}
+
+// Returns up to n last lines of the file written after the offset.
+func readLastLines(path string, offset int64, n int) []string {
+	data, err := ioutil.ReadFile(path)
+	if err != nil || offset > int64(len(data)) {
+		return nil
+	}
+
+	text := strings.TrimRight(string(data[offset:]), "\n")
+	if text == "" {
+		return nil
+	}
+
+	lines := strings.Split(text, "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return lines
+}
+
+// Returns current position in the server log file. Lines written to the
+// file after the mark are returned by LogSince and WaitForLogSince,
+// even if they were not read yet when the mark was taken.
+func (node *PostgresNode) LogMark() LogMark {
+	return LogMark(fileSize(node.pgLogFile))
+}
+
+// Returns server log lines written after the mark.
+func (node *PostgresNode) LogSince(mark LogMark) []string {
+	lines, _ := node.serverLog.since(node.serverLog.index(int64(mark)))
+	return lines
+}
+
+// Waits until a line matching the regexp appears in the server log
+// and returns it. All lines read since the node was created are checked.
+func (node *PostgresNode) WaitForLog(ctx context.Context,
+	re *regexp.Regexp) (string, error) {
+
+	return node.WaitForLogSince(ctx, 0, re)
+}
+
+// Waits until a line matching the regexp appears in the server log after
+// the mark and returns it. On timeout the error contains last log lines.
+func (node *PostgresNode) WaitForLogSince(ctx context.Context, mark LogMark,
+	re *regexp.Regexp) (string, error) {
+
+	pos := node.serverLog.index(int64(mark))
+	for {
+		lines, updated := node.serverLog.since(pos)
+		for _, line := range lines {
+			if re.MatchString(line) {
+				return line, nil
+			}
+		}
+		pos += len(lines)
+
+		select {
+		case <-updated:
+		case <-ctx.Done():
+			return "", fmt.Errorf("%s: log line matching %q not found, last lines:\n%s",
+				ctx.Err(), re, strings.Join(node.serverLog.tail(logTailLines), "\n"))
+		}
+	}
+}
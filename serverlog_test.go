@@ -0,0 +1,96 @@
+package pqt
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestWaitForLog(t *testing.T) {
+	node := MakePostgresNode("master")
+	node.Init()
+	node.Start()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	line, err := node.WaitForLog(ctx,
+		regexp.MustCompile("database system is ready to accept connections"))
+	assert.Nil(t, err)
+	assert.NotEqual(t, line, "")
+
+	mark := node.LogMark()
+	node.Execute("postgres", "select 'pqt_marker'")
+
+	line, err = node.WaitForLogSince(ctx, mark, regexp.MustCompile("pqt_marker"))
+	assert.Nil(t, err)
+	assert.Contains(t, line, "select 'pqt_marker'")
+	assert.NotEqual(t, len(node.LogSince(mark)), 0)
+
+	shortCtx, shortCancel := context.WithTimeout(context.Background(),
+		100*time.Millisecond)
+	defer shortCancel()
+
+	_, err = node.WaitForLogSince(shortCtx, node.LogMark(),
+		regexp.MustCompile("pqt_marker"))
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "last lines")
+
+	node.Stop()
+}
+
+func TestReadLastLines(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pqt_")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "postgresql.log")
+	assert.Nil(t, ioutil.WriteFile(path, []byte("old\nfirst\nsecond\nthird\n"), 0644))
+
+	assert.Equal(t, []string{"second", "third"}, readLastLines(path, 4, 2))
+	assert.Equal(t, []string{"first", "second", "third"}, readLastLines(path, 4, 20))
+	assert.Nil(t, readLastLines(path, fileSize(path), 20))
+	assert.Nil(t, readLastLines(filepath.Join(dir, "missing"), 0, 20))
+}
+
+func TestLogMark(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pqt_")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	node := MakePostgresNode("master")
+	node.SetLogSink(DiscardLogSink)
+	node.pgLogFile = filepath.Join(dir, "postgresql.log")
+	assert.Nil(t, ioutil.WriteFile(node.pgLogFile, []byte("old pqt_marker\n"), 0644))
+
+	// the line is written before the mark but read after it
+	mark := node.LogMark()
+	f, err := os.OpenFile(node.pgLogFile, os.O_APPEND|os.O_WRONLY, 0644)
+	assert.Nil(t, err)
+	f.WriteString("new line\n")
+	f.Close()
+
+	node.tailLog(node.pgLogFile, 0)
+	defer node.stopLogTails()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err = node.WaitForLogSince(ctx, mark, regexp.MustCompile("new line"))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"new line"}, node.LogSince(mark))
+
+	shortCtx, shortCancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer shortCancel()
+	_, err = node.WaitForLogSince(shortCtx, mark, regexp.MustCompile("pqt_marker"))
+	assert.NotNil(t, err)
+
+	line, err := node.WaitForLog(ctx, regexp.MustCompile("pqt_marker"))
+	assert.Nil(t, err)
+	assert.Equal(t, "old pqt_marker", line)
+}
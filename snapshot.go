@@ -0,0 +1,113 @@
+package pqt
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Child processes of postmaster at some point.
+type ProcessSnapshot struct {
+	Time      time.Time
+	Processes map[int]*Process
+}
+
+// Process which changed its type between snapshots.
+type ProcessChange struct {
+	Before *Process
+	After  *Process
+}
+
+// Difference between two process snapshots.
+type ProcessDiff struct {
+	Appeared    []*Process
+	Disappeared []*Process
+	Changed     []ProcessChange
+}
+
+// Takes a snapshot of postmaster child processes. The snapshot is empty
+// if the node is not running.
+func (node *PostgresNode) ProcessSnapshot() *ProcessSnapshot {
+	snapshot := &ProcessSnapshot{
+		Time:      time.Now(),
+		Processes: make(map[int]*Process),
+	}
+
+	if postmaster := node.GetProcess(); postmaster != nil {
+		for _, child := range postmaster.Children() {
+			snapshot.Processes[child.Pid] = child
+		}
+	}
+	return snapshot
+}
+
+func sortProcesses(processes []*Process) {
+	sort.Slice(processes, func(i, j int) bool {
+		return processes[i].Pid < processes[j].Pid
+	})
+}
+
+// Returns processes appeared, disappeared or changed their type in the
+// other snapshot. A reused pid is reported as a disappeared and
+// an appeared process.
+func (snapshot *ProcessSnapshot) Diff(other *ProcessSnapshot) *ProcessDiff {
+	diff := &ProcessDiff{}
+
+	for pid, before := range snapshot.Processes {
+		after, ok := other.Processes[pid]
+		if !ok || !after.StartTime.Equal(before.StartTime) {
+			diff.Disappeared = append(diff.Disappeared, before)
+		} else if after.Type != before.Type {
+			diff.Changed = append(diff.Changed, ProcessChange{before, after})
+		}
+	}
+
+	for pid, after := range other.Processes {
+		before, ok := snapshot.Processes[pid]
+		if !ok || !after.StartTime.Equal(before.StartTime) {
+			diff.Appeared = append(diff.Appeared, after)
+		}
+	}
+
+	sortProcesses(diff.Appeared)
+	sortProcesses(diff.Disappeared)
+	sort.Slice(diff.Changed, func(i, j int) bool {
+		return diff.Changed[i].Before.Pid < diff.Changed[j].Before.Pid
+	})
+	return diff
+}
+
+// Returns true if there are no differences.
+func (diff *ProcessDiff) Empty() bool {
+	return len(diff.Appeared) == 0 && len(diff.Disappeared) == 0 &&
+		len(diff.Changed) == 0
+}
+
+// Returns appeared processes of the type, like leaked client backends.
+func (diff *ProcessDiff) AppearedOfType(ptype ProcessType) []*Process {
+	var result []*Process
+
+	for _, process := range diff.Appeared {
+		if process.Type == ptype {
+			result = append(result, process)
+		}
+	}
+	return result
+}
+
+func (diff *ProcessDiff) String() string {
+	var lines []string
+
+	for _, p := range diff.Appeared {
+		lines = append(lines, fmt.Sprintf("+ %d %s: %s", p.Pid, p.Type, p.CmdLine))
+	}
+	for _, p := range diff.Disappeared {
+		lines = append(lines, fmt.Sprintf("- %d %s: %s", p.Pid, p.Type, p.CmdLine))
+	}
+	for _, c := range diff.Changed {
+		lines = append(lines, fmt.Sprintf("~ %d %s -> %s", c.Before.Pid,
+			c.Before.Type, c.After.Type))
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,65 @@
+package pqt
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestProcessDiff(t *testing.T) {
+	started := time.Now()
+	before := &ProcessSnapshot{Processes: map[int]*Process{
+		10: {Pid: 10, Type: Checkpointer, StartTime: started},
+		11: {Pid: 11, Type: ClientBackend, StartTime: started},
+		12: {Pid: 12, Type: UnknownProcess, StartTime: started},
+		13: {Pid: 13, Type: ClientBackend, StartTime: started},
+	}}
+	after := &ProcessSnapshot{Processes: map[int]*Process{
+		10: {Pid: 10, Type: Checkpointer, StartTime: started},
+		12: {Pid: 12, Type: OtherBgWorker, StartTime: started},
+		13: {Pid: 13, Type: ClientBackend, StartTime: started.Add(time.Second)},
+		14: {Pid: 14, Type: ClientBackend, StartTime: started},
+	}}
+
+	diff := before.Diff(after)
+	assert.False(t, diff.Empty())
+	assert.Equal(t, 2, len(diff.Appeared))
+	assert.Equal(t, 13, diff.Appeared[0].Pid)
+	assert.Equal(t, 14, diff.Appeared[1].Pid)
+	assert.Equal(t, 2, len(diff.Disappeared))
+	assert.Equal(t, 11, diff.Disappeared[0].Pid)
+	assert.Equal(t, 13, diff.Disappeared[1].Pid)
+	assert.Equal(t, 1, len(diff.Changed))
+	assert.Equal(t, OtherBgWorker, diff.Changed[0].After.Type)
+	assert.Equal(t, 2, len(diff.AppearedOfType(ClientBackend)))
+	assert.Contains(t, diff.String(), "~ 12 unknown -> background worker")
+
+	assert.True(t, after.Diff(after).Empty())
+}
+
+func TestProcessSnapshot(t *testing.T) {
+	node := MakePostgresNode("master")
+	node.Init()
+	node.Start()
+	defer node.Stop()
+
+	before := node.ProcessSnapshot()
+	assert.NotEqual(t, 0, len(before.Processes))
+
+	conn := MakePostgresConn(node, "postgres")
+	pid := conn.Pid()
+
+	leaked := before.Diff(node.ProcessSnapshot()).AppearedOfType(ClientBackend)
+	assert.Equal(t, 1, len(leaked))
+	assert.Equal(t, pid, leaked[0].Pid)
+
+	node.CloseConnections()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	assert.Nil(t, node.WaitForProcessExit(ctx, pid))
+
+	diff := before.Diff(node.ProcessSnapshot())
+	assert.Equal(t, 0, len(diff.AppearedOfType(ClientBackend)), diff.String())
+}
@@ -0,0 +1,288 @@
+package pqt
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type LogFormat string
+
+const (
+	LogFormatCSV  LogFormat = "csvlog"
+	LogFormatJSON LogFormat = "jsonlog"
+)
+
+const (
+	logEntriesBuffer = 1024
+	logTimeLayout    = "2006-01-02 15:04:05.000 MST"
+)
+
+// Server log entry parsed from csvlog or jsonlog.
+type LogEntry struct {
+	Time            time.Time
+	User            string
+	Database        string
+	Pid             int
+	BackendType     string
+	Severity        string
+	SQLState        string
+	Message         string
+	Detail          string
+	Hint            string
+	Context         string
+	Query           string
+	ApplicationName string
+}
+
+// Entries of the structured log and their subscribers.
+type structuredLog struct {
+	mutex       sync.Mutex
+	entries     []*LogEntry
+	subscribers []chan *LogEntry
+}
+
+func (sl *structuredLog) append(entry *LogEntry) {
+	sl.mutex.Lock()
+	defer sl.mutex.Unlock()
+
+	sl.entries = append(sl.entries, entry)
+	for _, ch := range sl.subscribers {
+		select {
+		case ch <- entry:
+		default:
+			log.Print("log entries buffer is full")
+		}
+	}
+}
+
+// Removes the subscriber and closes its channel.
+func (sl *structuredLog) unsubscribe(ch chan *LogEntry) {
+	sl.mutex.Lock()
+	defer sl.mutex.Unlock()
+
+	for i := range sl.subscribers {
+		if sl.subscribers[i] == ch {
+			sl.subscribers = append(sl.subscribers[:i], sl.subscribers[i+1:]...)
+			close(ch)
+			break
+		}
+	}
+}
+
+// Forgets all entries and closes channels of all subscribers.
+func (sl *structuredLog) reset() {
+	sl.mutex.Lock()
+	defer sl.mutex.Unlock()
+
+	for _, ch := range sl.subscribers {
+		close(ch)
+	}
+	sl.subscribers = nil
+	sl.entries = nil
+}
+
+// Makes the node write csvlog or jsonlog (postgres 15+) in addition to
+// the usual log. Entries of the log are parsed and available by
+// LogEntries, FindLogEntries and WatchLog. Should be called before Start.
+func (node *PostgresNode) EnableStructuredLog(format LogFormat) error {
+	if node.status == INITIAL {
+		return errors.New("node has not been initialized")
+	}
+	if format == LogFormatJSON && node.Version() < 150000 {
+		return errors.New("jsonlog is supported since postgres 15")
+	}
+
+	logFile := node.logFile()
+	node.AppendConf("postgresql.conf", fmt.Sprintf(`
+logging_collector = on
+log_destination = 'stderr,%s'
+log_directory = '%s'
+log_filename = '%s'
+log_rotation_age = 0
+log_rotation_size = 0
+`, format, filepath.Dir(logFile), filepath.Base(logFile)))
+
+	node.logFormat = format
+	return nil
+}
+
+// Returns the file of structured log, which is named as the usual log
+// with .csv or .json extension.
+func (node *PostgresNode) structuredLogFile() string {
+	ext := ".csv"
+	if node.logFormat == LogFormatJSON {
+		ext = ".json"
+	}
+	return strings.TrimSuffix(node.logFile(), ".log") + ext
+}
+
+// Returns all parsed entries of the structured log.
+func (node *PostgresNode) LogEntries() []*LogEntry {
+	return node.FindLogEntries(nil)
+}
+
+// Returns entries of the structured log matching the filter.
+func (node *PostgresNode) FindLogEntries(filter func(*LogEntry) bool) []*LogEntry {
+	var result []*LogEntry
+
+	node.structuredLog.mutex.Lock()
+	defer node.structuredLog.mutex.Unlock()
+
+	for _, entry := range node.structuredLog.entries {
+		if filter == nil || filter(entry) {
+			result = append(result, entry)
+		}
+	}
+	return result
+}
+
+// Returns a channel receiving new entries of the structured log and
+// a function that stops watching and closes the channel. If the channel
+// buffer is full, new entries are not sent to it. Channels of all
+// watchers are closed when the node is destroyed.
+func (node *PostgresNode) WatchLog() (<-chan *LogEntry, func()) {
+	ch := make(chan *LogEntry, logEntriesBuffer)
+
+	node.structuredLog.mutex.Lock()
+	defer node.structuredLog.mutex.Unlock()
+
+	node.structuredLog.subscribers = append(node.structuredLog.subscribers, ch)
+	return ch, func() { node.structuredLog.unsubscribe(ch) }
+}
+
+func parseLogTime(value string) time.Time {
+	t, _ := time.Parse(logTimeLayout, value)
+	return t
+}
+
+// Parses a csvlog record.
+func parseCSVLogEntry(record []string) (*LogEntry, error) {
+	if len(record) < 23 {
+		return nil, fmt.Errorf("csvlog record has %d fields", len(record))
+	}
+
+	pid, _ := strconv.Atoi(record[3])
+	entry := &LogEntry{
+		Time:            parseLogTime(record[0]),
+		User:            record[1],
+		Database:        record[2],
+		Pid:             pid,
+		Severity:        record[11],
+		SQLState:        record[12],
+		Message:         record[13],
+		Detail:          record[14],
+		Hint:            record[15],
+		Context:         record[18],
+		Query:           record[19],
+		ApplicationName: record[22],
+	}
+
+	// backend_type is logged since postgres 13
+	if len(record) > 23 {
+		entry.BackendType = record[23]
+	}
+	return entry, nil
+}
+
+// Parses a jsonlog line.
+func parseJSONLogEntry(line string) (*LogEntry, error) {
+	var item struct {
+		Timestamp       string `json:"timestamp"`
+		User            string `json:"user"`
+		Dbname          string `json:"dbname"`
+		Pid             int    `json:"pid"`
+		BackendType     string `json:"backend_type"`
+		ErrorSeverity   string `json:"error_severity"`
+		StateCode       string `json:"state_code"`
+		Message         string `json:"message"`
+		Detail          string `json:"detail"`
+		Hint            string `json:"hint"`
+		Context         string `json:"context"`
+		Statement       string `json:"statement"`
+		ApplicationName string `json:"application_name"`
+	}
+
+	if err := json.Unmarshal([]byte(line), &item); err != nil {
+		return nil, err
+	}
+
+	return &LogEntry{
+		Time:            parseLogTime(item.Timestamp),
+		User:            item.User,
+		Database:        item.Dbname,
+		Pid:             item.Pid,
+		BackendType:     item.BackendType,
+		Severity:        item.ErrorSeverity,
+		SQLState:        item.StateCode,
+		Message:         item.Message,
+		Detail:          item.Detail,
+		Hint:            item.Hint,
+		Context:         item.Context,
+		Query:           item.Statement,
+		ApplicationName: item.ApplicationName,
+	}, nil
+}
+
+// Collects csvlog lines to complete records, which can be multiline.
+type csvLogParser struct {
+	pending strings.Builder
+}
+
+// Adds a line and returns parsed entry if the record is complete.
+func (p *csvLogParser) add(line string) (*LogEntry, error) {
+	if p.pending.Len() > 0 {
+		p.pending.WriteString("\n")
+	}
+	p.pending.WriteString(line)
+
+	// the record is complete when all quoted fields are closed
+	text := p.pending.String()
+	if strings.Count(text, `"`)%2 != 0 {
+		return nil, nil
+	}
+	p.pending.Reset()
+
+	record, err := csv.NewReader(strings.NewReader(text)).Read()
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	return parseCSVLogEntry(record)
+}
+
+// Starts reading and parsing new entries of csvlog or jsonlog.
+func (node *PostgresNode) tailStructuredLog(filename string, offset int64) {
+	var parser csvLogParser
+
+	lt, err := startLogTail(filename, offset, func(line string) {
+		var (
+			entry *LogEntry
+			err   error
+		)
+
+		if node.logFormat == LogFormatJSON {
+			entry, err = parseJSONLogEntry(line)
+		} else {
+			entry, err = parser.add(line)
+		}
+
+		if err != nil {
+			log.Printf("%s: can't parse log entry: %s", node.name, err)
+		} else if entry != nil {
+			node.structuredLog.append(entry)
+		}
+	})
+	if err != nil {
+		log.Print("can't tail file: ", filename)
+		return
+	}
+	node.addLogTail(lt)
+}
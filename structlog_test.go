@@ -0,0 +1,98 @@
+package pqt
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestParseCSVLogEntry(t *testing.T) {
+	var parser csvLogParser
+
+	entry, err := parser.add(`2024-03-01 10:20:30.123 UTC,"postgres","postgres",1234,"[local]",65e1a0b2.4d2,1,"SELECT",2024-03-01 10:20:29 UTC,3/2,0,ERROR,42P01,"relation ""t"" does not exist",,,,,,"select * from`)
+	assert.Nil(t, err)
+	assert.Nil(t, entry)
+
+	entry, err = parser.add(`t",15,,"psql","client backend",,0`)
+	assert.Nil(t, err)
+	assert.NotNil(t, entry)
+	assert.Equal(t, "postgres", entry.User)
+	assert.Equal(t, 1234, entry.Pid)
+	assert.Equal(t, "ERROR", entry.Severity)
+	assert.Equal(t, "42P01", entry.SQLState)
+	assert.Equal(t, `relation "t" does not exist`, entry.Message)
+	assert.Equal(t, "select * from\nt", entry.Query)
+	assert.Equal(t, "psql", entry.ApplicationName)
+	assert.Equal(t, "client backend", entry.BackendType)
+	assert.Equal(t, 2024, entry.Time.Year())
+}
+
+func TestParseJSONLogEntry(t *testing.T) {
+	entry, err := parseJSONLogEntry(`{"timestamp":"2024-03-01 10:20:30.123 UTC","user":"postgres","dbname":"postgres","pid":1234,"error_severity":"ERROR","state_code":"XX000","message":"internal error","statement":"select 1","backend_type":"client backend"}`)
+	assert.Nil(t, err)
+	assert.Equal(t, 1234, entry.Pid)
+	assert.Equal(t, "XX000", entry.SQLState)
+	assert.Equal(t, "select 1", entry.Query)
+	assert.Equal(t, "client backend", entry.BackendType)
+
+	_, err = parseJSONLogEntry("not json")
+	assert.NotNil(t, err)
+}
+
+func TestStructuredLog(t *testing.T) {
+	node := MakePostgresNode("master")
+	node.Init()
+	assert.Nil(t, node.EnableStructuredLog(LogFormatCSV))
+	node.Start()
+	defer node.Stop()
+
+	entries, unwatch := node.WatchLog()
+	defer unwatch()
+	node.Execute("postgres", "do $$ begin raise warning 'pqt_structured'; end $$")
+
+	timeout := time.After(10 * time.Second)
+	for found := false; !found; {
+		select {
+		case entry := <-entries:
+			found = entry.Message == "pqt_structured"
+		case <-timeout:
+			t.Fatal("log entry was not received")
+		}
+	}
+
+	warnings := node.FindLogEntries(func(e *LogEntry) bool {
+		return e.Severity == "WARNING" && e.Message == "pqt_structured"
+	})
+	assert.Equal(t, 1, len(warnings))
+	assert.Equal(t, "01000", warnings[0].SQLState)
+
+	internal := node.FindLogEntries(func(e *LogEntry) bool {
+		return e.SQLState == "XX000"
+	})
+	assert.Equal(t, 0, len(internal))
+}
+
+func TestStructuredLogWatchers(t *testing.T) {
+	var sl structuredLog
+
+	first := make(chan *LogEntry, 1)
+	second := make(chan *LogEntry, 1)
+	sl.subscribers = []chan *LogEntry{first, second}
+
+	sl.unsubscribe(first)
+	_, ok := <-first
+	assert.False(t, ok)
+
+	// closed channels are not closed twice
+	sl.unsubscribe(first)
+
+	entry := &LogEntry{Message: "test"}
+	sl.append(entry)
+	assert.Equal(t, entry, <-second)
+
+	sl.reset()
+	_, ok = <-second
+	assert.False(t, ok)
+	assert.Equal(t, 0, len(sl.subscribers))
+	assert.Equal(t, 0, len(sl.entries))
+}
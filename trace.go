@@ -108,6 +108,62 @@ func (di *DebugInformation) LookupFunction(funcName string) (uint64, error) {
 	return 0, fmt.Errorf("function is not found")
 }
 
+// Returns function, source file and line of the address.
+func (di *DebugInformation) LookupAddress(addr uint64) (string, string, int, error) {
+	reader := di.dwarfData.Reader()
+
+	cu, err := reader.SeekPC(addr)
+	if err != nil {
+		return "", "", 0, err
+	}
+
+	var (
+		funcName string
+		fileName string
+		line     int
+	)
+
+	for {
+		entry, err := reader.Next()
+		if err != nil {
+			return "", "", 0, err
+		}
+		if entry == nil || entry.Tag == dwarf.TagCompileUnit {
+			break
+		}
+		if entry.Tag != dwarf.TagSubprogram {
+			continue
+		}
+
+		ranges, err := di.dwarfData.Ranges(entry)
+		if err != nil {
+			continue
+		}
+		for _, r := range ranges {
+			if addr >= r[0] && addr < r[1] {
+				funcName, _ = entry.Val(dwarf.AttrName).(string)
+			}
+		}
+		if funcName != "" {
+			break
+		}
+	}
+
+	lineReader, err := di.dwarfData.LineReader(cu)
+	if err == nil && lineReader != nil {
+		var entry dwarf.LineEntry
+		if lineReader.SeekPC(addr, &entry) == nil {
+			fileName = entry.File.Name
+			line = entry.Line
+		}
+	}
+
+	if funcName == "" {
+		return "", "", 0, fmt.Errorf("function is not found for address %#x", addr)
+	}
+	return funcName, fileName, line, nil
+}
+
 func getDebugInformation(path string) *DebugInformation {
 	f, err := elf.Open(path)
 	if err != nil {
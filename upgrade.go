@@ -0,0 +1,224 @@
+package pqt
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type UpgradeMode int
+
+// Settings of postgresql.conf that are specific to the node and are not
+// copied to the upgraded cluster.
+var nodeConfRe = regexp.MustCompile(`^\s*(port|listen_addresses)\s*=`)
+
+const (
+	UpgradeCopy  UpgradeMode = iota
+	UpgradeLink  UpgradeMode = iota
+	UpgradeClone UpgradeMode = iota
+)
+
+// Error returned when the upgrade fails. Contains pg_upgrade output and
+// the contents of log files it has left.
+type UpgradeError struct {
+	Err    error
+	Output string
+	Logs   map[string]string
+}
+
+func (e *UpgradeError) Error() string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "upgrade failed: %s", e.Err)
+	if e.Output != "" {
+		fmt.Fprintf(&b, "\n%s", e.Output)
+	}
+
+	names := make([]string, 0, len(e.Logs))
+	for name := range e.Logs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		fmt.Fprintf(&b, "\n==> %s <==\n%s", name, e.Logs[name])
+	}
+	return b.String()
+}
+
+func (e *UpgradeError) Unwrap() error {
+	return e.Err
+}
+
+// Returns encoding, collation and ctype of the cluster.
+func (node *PostgresNode) getLocale() (encoding, collate, ctype string) {
+	rows := node.Fetch("postgres", `select pg_encoding_to_char(encoding),
+		datcollate, datctype from pg_database where datname = 'template1'`)
+	defer rows.Close()
+
+	rows.Next()
+	err := rows.Scan(&encoding, &collate, &ctype)
+	if err != nil {
+		log.Panic("can't get cluster locale: ", err)
+	}
+	return encoding, collate, ctype
+}
+
+// Collects log files left by pg_upgrade in specified directories.
+func collectUpgradeLogs(dirs ...string) map[string]string {
+	logs := make(map[string]string)
+
+	for _, dir := range dirs {
+		filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil || info.IsDir() {
+				return nil
+			}
+			ext := filepath.Ext(path)
+			if ext != ".log" && ext != ".txt" {
+				return nil
+			}
+			data, err := ioutil.ReadFile(path)
+			if err == nil {
+				logs[path] = string(data)
+			}
+			return nil
+		})
+	}
+	return logs
+}
+
+// Copies configuration of the node to the new node. Lines of
+// postgresql.conf are appended except for the port and listen
+// addresses, other configuration files are replaced.
+func (node *PostgresNode) copyConf(newNode *PostgresNode) error {
+	data, err := ioutil.ReadFile(filepath.Join(node.dataDirectory,
+		"postgresql.conf"))
+	if err != nil {
+		return err
+	}
+
+	var lines strings.Builder
+	for _, line := range strings.Split(string(data), "\n") {
+		if !nodeConfRe.MatchString(line) {
+			lines.WriteString(line + "\n")
+		}
+	}
+	newNode.AppendConf("postgresql.conf", lines.String())
+
+	for _, name := range []string{"postgresql.auto.conf", "pg_hba.conf",
+		"pg_ident.conf"} {
+
+		data, err := ioutil.ReadFile(filepath.Join(node.dataDirectory, name))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		err = ioutil.WriteFile(filepath.Join(newNode.dataDirectory, name),
+			data, 0600)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Upgrades the node to the specified installation using pg_upgrade.
+// The node is stopped, a new cluster is initialized with the same
+// encoding, locale and configuration, checked by pg_upgrade --check
+// and then upgraded.
+// The upgraded node is started and returned. In link mode the old node
+// can't be started after the upgrade.
+func (node *PostgresNode) Upgrade(install *PostgresInstall,
+	mode UpgradeMode) (upgraded *PostgresNode, err error) {
+
+	if node.status == INITIAL {
+		return nil, errors.New("node has not been initialized")
+	}
+
+	if node.status == STOPPED {
+		if _, err := node.Start(); err != nil {
+			return nil, &UpgradeError{Err: fmt.Errorf("can't start old node: %w", err)}
+		}
+	}
+	encoding, collate, ctype := node.getLocale()
+	if _, err := node.Stop(); err != nil {
+		return nil, &UpgradeError{Err: fmt.Errorf("can't stop old node: %w", err)}
+	}
+
+	newNode := MakePostgresNodeWithInstall(node.name, install)
+	_, err = newNode.Init("-E", encoding, "--lc-collate", collate,
+		"--lc-ctype", ctype)
+	if err != nil {
+		return nil, &UpgradeError{Err: fmt.Errorf("can't init new node: %w", err)}
+	}
+	defer func() {
+		if err != nil {
+			newNode.Destroy()
+		}
+	}()
+
+	if err := node.copyConf(newNode); err != nil {
+		return nil, &UpgradeError{Err: fmt.Errorf("can't copy configuration: %w", err)}
+	}
+
+	workDir := filepath.Join(newNode.baseDirectory, "upgrade")
+	if err := os.Mkdir(workDir, os.ModePerm); err != nil {
+		return nil, &UpgradeError{Err: err}
+	}
+
+	args := []string{
+		"-b", node.Install().BinDir(),
+		"-B", install.BinDir(),
+		"-d", node.dataDirectory,
+		"-D", newNode.dataDirectory,
+		"-p", strconv.Itoa(node.Port),
+		"-P", strconv.Itoa(newNode.Port),
+		"-U", node.user,
+	}
+
+	switch mode {
+	case UpgradeLink:
+		args = append(args, "--link")
+	case UpgradeClone:
+		args = append(args, "--clone")
+	}
+
+	for _, check := range []bool{true, false} {
+		var out bytes.Buffer
+
+		cmdArgs := args
+		if check {
+			cmdArgs = append([]string{"--check"}, args...)
+		}
+
+		// pg_upgrade writes its logs and sockets to the current directory
+		cmd := install.command("pg_upgrade", cmdArgs...)
+		cmd.Dir = workDir
+		cmd.Stdout = &out
+		cmd.Stderr = &out
+
+		if err := cmd.Run(); err != nil {
+			return nil, &UpgradeError{
+				Err:    err,
+				Output: out.String(),
+				Logs: collectUpgradeLogs(workDir,
+					filepath.Join(newNode.dataDirectory, "pg_upgrade_output.d")),
+			}
+		}
+	}
+
+	if _, err := newNode.Start(); err != nil {
+		return nil, &UpgradeError{Err: fmt.Errorf("can't start new node: %w", err)}
+	}
+	return newNode, nil
+}
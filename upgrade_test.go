@@ -0,0 +1,54 @@
+package pqt
+
+import (
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestUpgrade(t *testing.T) {
+	var count int
+
+	node := MakePostgresNode("master")
+	node.Init()
+	node.AppendConf("postgresql.conf", "work_mem = '7MB'\n")
+	node.Start()
+	node.Execute("postgres", "alter system set maintenance_work_mem = '17MB'")
+	node.Execute("postgres", "create table one(a int)")
+	node.Execute("postgres", "insert into one select generate_series(1, 100)")
+
+	upgraded, err := node.Upgrade(DefaultInstall(), UpgradeCopy)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	rows := upgraded.Fetch("postgres", "select count(*) from one")
+	rows.Next()
+	rows.Scan(&count)
+	rows.Close()
+	assert.Equal(t, count, 100)
+
+	// configuration is copied
+	assert.True(t, AssertQueryResult(t, upgraded, "postgres",
+		"select current_setting('work_mem'), "+
+			"current_setting('maintenance_work_mem')",
+		[][]interface{}{{"7MB", "17MB"}}))
+	assert.NotEqual(t, node.Port, upgraded.Port)
+
+	upgraded.Stop()
+}
+
+func TestUpgradeError(t *testing.T) {
+	cause := errors.New("node has been started already")
+	err := &UpgradeError{Err: fmt.Errorf("can't start old node: %w", cause)}
+	assert.True(t, errors.Is(err, cause))
+	assert.Equal(t, "upgrade failed: can't start old node: "+
+		"node has been started already", err.Error())
+}
+
+func TestUpgradeNotInitialized(t *testing.T) {
+	node := MakePostgresNode("master")
+	_, err := node.Upgrade(DefaultInstall(), UpgradeCopy)
+	assert.NotNil(t, err)
+}
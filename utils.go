@@ -2,62 +2,63 @@ package pqt
 
 import (
 	"bytes"
+	"context"
 	"log"
 	"net"
 	"os"
 	"os/exec"
-	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
 )
 
+const pollInterval = 10 * time.Millisecond
+
 var (
 	currentPort int = 9999
 )
 
-func execUtility(name string, args ...string) string {
-	var out bytes.Buffer
-	var errout bytes.Buffer
-
-	cmd := exec.Command(getBinPath(name), args...)
-	cmd.Stdout = &out
-	cmd.Stderr = &errout
-	err := cmd.Run()
+// Calls check until it returns true, an error or the context is done.
+func poll(ctx context.Context, check func() (bool, error)) error {
+	for {
+		ok, err := check()
+		if err != nil {
+			return err
+		}
+		if ok {
+			return nil
+		}
 
-	if err != nil {
-		log.Print(errout.String())
-		log.Panic(name, " launch error: ", err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(pollInterval):
+		}
 	}
-
-	return out.String()
 }
 
 func getBinPath(filename string) string {
-	if path, _ := filepath.Abs(filename); path == filename {
-		return filename
+	return DefaultInstall().BinPath(filename)
+}
+
+// Returns pg_config path from $PG_CONFIG or $PATH.
+func findPgConfig() string {
+	if len(os.Getenv("PG_CONFIG")) > 0 {
+		return os.Getenv("PG_CONFIG")
 	}
 
-	bindir := getPgConfig()["BINDIR"]
-	return filepath.Join(bindir, filename)
+	path, err := exec.LookPath("pg_config")
+	if err != nil {
+		log.Panic("pg_config is not found in $PATH")
+	}
+	return path
 }
 
-func getPgConfig() map[string]string {
-	var pg_config_path string
+func getPgConfig(pg_config_path string) map[string]string {
 	var out bytes.Buffer
 
 	result := make(map[string]string)
 
-	if len(os.Getenv("PG_CONFIG")) > 0 {
-		pg_config_path = os.Getenv("PG_CONFIG")
-	} else {
-		path, err := exec.LookPath("pg_config")
-		if err != nil {
-			log.Panic("pg_config is not found in $PATH")
-		}
-		pg_config_path = path
-	}
-
 	if _, err := os.Stat(pg_config_path); os.IsNotExist(err) {
 		log.Panic("pg_config is not found")
 	}
@@ -82,6 +83,15 @@ func getPgConfig() map[string]string {
 	return result
 }
 
+// Returns file size, or 0 if the file doesn't exist.
+func fileSize(path string) int64 {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
+
 func getAvailablePort() int {
 	var initial int = currentPort + 1
 
@@ -0,0 +1,31 @@
+package pqt
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestPoll(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	calls := 0
+	err := poll(ctx, func() (bool, error) {
+		calls++
+		return calls == 3, nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 3, calls)
+
+	failure := errors.New("failure")
+	err = poll(ctx, func() (bool, error) { return false, failure })
+	assert.Equal(t, failure, err)
+
+	shortCtx, shortCancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer shortCancel()
+	err = poll(shortCtx, func() (bool, error) { return false, nil })
+	assert.Equal(t, context.DeadlineExceeded, err)
+}
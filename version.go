@@ -0,0 +1,144 @@
+package pqt
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"testing"
+)
+
+var (
+	versionRe           = regexp.MustCompile(`(\d+)(?:\.(\d+))?(?:\.(\d+))?`)
+	versionConstraintRe = regexp.MustCompile(`^\s*(>=|<=|==|!=|=|>|<)?\s*(\S+)\s*$`)
+)
+
+// Parses a version as reported by `postgres --version` or server_version
+// (like "9.6.3", "15.4" or "17devel") to server_version_num format.
+func ParseVersion(version string) (int, error) {
+	num, _, err := parseVersion(version)
+	return num, err
+}
+
+// Returns version number and number of parsed version parts.
+func parseVersion(version string) (num int, parts int, err error) {
+	match := versionRe.FindStringSubmatch(version)
+	if match == nil {
+		return 0, 0, fmt.Errorf("can't parse version %q", version)
+	}
+
+	var nums [3]int
+	for i, part := range match[1:] {
+		if part == "" {
+			break
+		}
+		nums[i], _ = strconv.Atoi(part)
+		parts += 1
+	}
+
+	if nums[0] >= 10 {
+		num = nums[0]*10000 + nums[1]
+	} else {
+		num = nums[0]*10000 + nums[1]*100 + nums[2]
+	}
+	return num, parts, nil
+}
+
+// Checks version number against a constraint like ">= 13" or "< 9.6".
+// Versions are compared up to the precision of the constraint, so
+// "<= 13" matches 13.4 and "== 9.6" matches 9.6.3.
+func MatchVersion(version int, constraint string) (bool, error) {
+	match := versionConstraintRe.FindStringSubmatch(constraint)
+	if match == nil {
+		return false, fmt.Errorf("can't parse version constraint %q", constraint)
+	}
+
+	required, parts, err := parseVersion(match[2])
+	if err != nil {
+		return false, err
+	}
+
+	// cut minor parts that are not specified in the constraint
+	if required >= 100000 && parts == 1 {
+		version = version / 10000 * 10000
+	} else if required < 100000 && parts < 3 {
+		step := 10000
+		if parts == 2 {
+			step = 100
+		}
+		version = version / step * step
+	}
+
+	switch match[1] {
+	case ">=":
+		return version >= required, nil
+	case "<=":
+		return version <= required, nil
+	case ">":
+		return version > required, nil
+	case "<":
+		return version < required, nil
+	case "!=":
+		return version != required, nil
+	default:
+		return version == required, nil
+	}
+}
+
+// Returns installation version in server_version_num format.
+func (install *PostgresInstall) Version() int {
+	if install.version == 0 {
+		out := install.execUtility("postgres", "--version")
+		version, err := ParseVersion(out)
+		if err != nil {
+			log.Panic("can't get postgres version: ", err)
+		}
+		install.version = version
+	}
+	return install.version
+}
+
+// Returns server version in server_version_num format. The version is
+// queried from the server once after the node is started, otherwise it's
+// taken from the postgres binary. The query is made by a separate
+// connection, so the default connection is not affected.
+func (node *PostgresNode) Version() int {
+	if node.status != STARTED {
+		return node.Install().Version()
+	}
+
+	node.mutex.Lock()
+	version := node.serverVersion
+	node.mutex.Unlock()
+	if version != 0 {
+		return version
+	}
+
+	conn := MakePostgresConn(node, "postgres")
+	defer conn.Close()
+
+	version, err := QueryScalar[int](conn,
+		"select current_setting('server_version_num')::int")
+	if err != nil {
+		log.Panic("can't get server version: ", err)
+	}
+
+	node.mutex.Lock()
+	node.serverVersion = version
+	node.mutex.Unlock()
+	return version
+}
+
+// Skips the test if the default installation doesn't match
+// the version constraint, for example ">= 13".
+func RequireVersion(t testing.TB, constraint string) {
+	version := DefaultInstall().Version()
+
+	ok, err := MatchVersion(version, constraint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		t.Skipf("postgres version %d doesn't match %q", version, constraint)
+	}
+}
@@ -0,0 +1,69 @@
+package pqt
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestParseVersion(t *testing.T) {
+	versions := map[string]int{
+		"postgres (PostgreSQL) 15.4 (Debian 15.4-1.pgdg120+1)": 150004,
+		"postgres (PostgreSQL) 9.6.3":                          90603,
+		"10.1":                                                 100001,
+		"17devel":                                              170000,
+		"16beta1":                                              160000,
+	}
+
+	for s, expected := range versions {
+		version, err := ParseVersion(s)
+		assert.Nil(t, err)
+		assert.Equal(t, expected, version, s)
+	}
+
+	_, err := ParseVersion("postgres")
+	assert.NotNil(t, err)
+}
+
+func TestMatchVersion(t *testing.T) {
+	cases := []struct {
+		version    int
+		constraint string
+		expected   bool
+	}{
+		{130004, ">= 13", true},
+		{120010, ">= 13", false},
+		{130004, "<= 13", true},
+		{130004, "< 13", false},
+		{130004, "13", true},
+		{130004, "== 13.4", true},
+		{130004, "> 13.2", true},
+		{90603, "== 9.6", true},
+		{90603, "< 10", true},
+		{90603, ">=9.6.4", false},
+		{90603, "!= 9.5", true},
+	}
+
+	for _, c := range cases {
+		ok, err := MatchVersion(c.version, c.constraint)
+		assert.Nil(t, err)
+		assert.Equal(t, c.expected, ok, "%d %s", c.version, c.constraint)
+	}
+
+	_, err := MatchVersion(130000, ">= x")
+	assert.NotNil(t, err)
+}
+
+func TestNodeVersion(t *testing.T) {
+	node := MakePostgresNode("master")
+	version := node.Version()
+	assert.NotEqual(t, version, 0)
+
+	node.Init()
+	node.Start()
+
+	// the default connection is not affected
+	conn := node.Conn("template1")
+	assert.Equal(t, version/10000, node.Version()/10000)
+	assert.Equal(t, conn, node.Conn("template1"))
+	node.Stop()
+}
@@ -0,0 +1,101 @@
+package pqt
+
+import (
+	"regexp"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// Message about a child process of postmaster killed by a signal, like
+// a backend, background worker or autovacuum worker.
+var crashPattern = regexp.MustCompile(`\(PID (\d+)\) was terminated by signal (\d+)`)
+
+// Server log messages which mean that something went wrong.
+var problemPatterns = []*regexp.Regexp{
+	crashPattern,
+	regexp.MustCompile(`TRAP: [A-Za-z]+`),
+	regexp.MustCompile(`\bPANIC: `),
+	regexp.MustCompile(`database system was not properly shut down`),
+}
+
+// Child process of postmaster killed by a signal.
+type crash struct {
+	pid    int
+	signal int
+}
+
+// Collects problems found in the server log.
+type watchdog struct {
+	mutex    sync.Mutex
+	enabled  bool
+	problems []string
+	crashes  []crash
+}
+
+func isProblem(line string) bool {
+	for _, re := range problemPatterns {
+		if re.MatchString(line) {
+			return true
+		}
+	}
+	return false
+}
+
+func (w *watchdog) check(line string) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.enabled && isProblem(line) {
+		w.problems = append(w.problems, line)
+	}
+
+	if m := crashPattern.FindStringSubmatch(line); m != nil {
+		pid, _ := strconv.Atoi(m[1])
+		signal, _ := strconv.Atoi(m[2])
+		w.crashes = append(w.crashes, crash{pid: pid, signal: signal})
+	}
+}
+
+func (w *watchdog) getCrashes() []crash {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	return append([]crash(nil), w.crashes...)
+}
+
+// Enables the watchdog which records server crashes, failed assertions,
+// PANICs and recovery after unclean shutdown found in the server log.
+// The problems are returned by Problems.
+func (node *PostgresNode) EnableWatchdog() {
+	node.watchdog.mutex.Lock()
+	defer node.watchdog.mutex.Unlock()
+
+	node.watchdog.enabled = true
+}
+
+// Enables the watchdog and fails the test at cleanup if any problem
+// was found in the server log.
+func (node *PostgresNode) Watch(t testing.TB) {
+	t.Helper()
+	node.EnableWatchdog()
+
+	t.Cleanup(func() {
+		for _, problem := range node.Problems() {
+			t.Errorf("%s: server log problem: %s", node.name, problem)
+		}
+		if node.coreDumps {
+			for _, dump := range node.CoreDumps() {
+				t.Logf("%s: %s", node.name, dump)
+			}
+		}
+	})
+}
+
+// Returns problems found by the watchdog.
+func (node *PostgresNode) Problems() []string {
+	node.watchdog.mutex.Lock()
+	defer node.watchdog.mutex.Unlock()
+
+	return append([]string(nil), node.watchdog.problems...)
+}
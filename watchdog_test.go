@@ -0,0 +1,41 @@
+package pqt
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestWatchdogCheck(t *testing.T) {
+	var w watchdog
+
+	line := "LOG:  server process (PID 123) was terminated by signal 11: Segmentation fault"
+	w.check(line)
+	assert.Equal(t, 0, len(w.problems))
+
+	w.enabled = true
+	w.check("LOG:  database system is ready to accept connections")
+	w.check(line)
+	w.check(`TRAP: FailedAssertion("false", File: "foo.c", Line: 10, PID: 123)`)
+	w.check("PANIC:  could not write to file")
+	w.check("LOG:  database system was not properly shut down; automatic recovery in progress")
+	w.check(`LOG:  background worker "pqt worker" (PID 124) was terminated by signal 6: Aborted`)
+	w.check("LOG:  autovacuum worker (PID 125) was terminated by signal 9: Killed")
+	assert.Equal(t, 6, len(w.problems))
+	assert.Equal(t, line, w.problems[0])
+
+	// crashes are recorded even if the watchdog is disabled
+	crashes := w.getCrashes()
+	assert.Equal(t, 4, len(crashes))
+	assert.Equal(t, crash{pid: 124, signal: 6}, crashes[2])
+}
+
+func TestWatchdog(t *testing.T) {
+	node := MakePostgresNode("master")
+	node.Init()
+	node.EnableWatchdog()
+	node.Start()
+	defer node.Stop()
+
+	node.Execute("postgres", "select 1")
+	assert.Equal(t, 0, len(node.Problems()))
+}